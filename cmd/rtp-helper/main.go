@@ -0,0 +1,2279 @@
+package main
+
+import (
+        "context"
+        "crypto/cipher"
+        "crypto/rand"
+        "crypto/sha256"
+        "encoding/binary"
+        "encoding/hex"
+        "encoding/json"
+        "flag"
+        "fmt"
+        "io"
+        "log"
+        "math"
+        "net"
+        "net/http"
+        "os"
+        "os/signal"
+        "path/filepath"
+        "strings"
+        "sync"
+        "sync/atomic"
+        "syscall"
+        "time"
+
+        "github.com/gorilla/websocket"
+        "github.com/libp2p/go-libp2p"
+        dht "github.com/libp2p/go-libp2p-kad-dht"
+        webrtc_direct "github.com/libp2p/go-libp2p-webrtc-direct"
+        "github.com/libp2p/go-libp2p/core/crypto"
+        "github.com/libp2p/go-libp2p/core/host"
+        "github.com/libp2p/go-libp2p/core/network"
+        "github.com/libp2p/go-libp2p/core/peer"
+        "github.com/libp2p/go-libp2p/core/protocol"
+        "github.com/libp2p/go-libp2p/p2p/net/connmgr"
+        "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+        dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
+        circuitv2client "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+        "github.com/libp2p/go-libp2p/p2p/security/noise"
+        libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
+        pubsub "github.com/libp2p/go-libp2p-pubsub"
+        "github.com/multiformats/go-multiaddr"
+        "github.com/pion/interceptor"
+        "github.com/pion/rtp"
+        "github.com/pion/webrtc/v3"
+        "golang.org/x/crypto/chacha20poly1305"
+        "golang.org/x/crypto/scrypt"
+        "golang.org/x/term"
+)
+
+const (
+        // WebSocket server port
+        WSPort = 52100
+
+        // Protocol for RTP forwarding
+        RTPProtocol = "/securelink/rtp/1.0.0"
+
+        // WebRTCSignalProtocol carries SDP/ICE signaling for a private
+        // WebRTC connection negotiated directly between two helpers. It's
+        // used as a fallback when a remote peer advertises only a
+        // /webrtc address, and so has no other transport we could open
+        // an RTPProtocol stream over.
+        WebRTCSignalProtocol = "/securelink/webrtc-signal/1.0.0"
+
+        // rtpFrameMaxSize bounds a single framed RTP packet read from the
+        // libp2p stream. RTP packets normally stay well under a network
+        // MTU, but we allow headroom for jumbo packets without risking
+        // an unbounded allocation from a malformed length prefix.
+        rtpFrameMaxSize = 1 << 16 // 64 KiB
+
+        // desiredRelayCount is how many distinct circuit v2 relays we try
+        // to hold reservations on at once, so a single relay dropping us
+        // doesn't leave symmetric-NAT peers unreachable.
+        desiredRelayCount = 2
+
+        // relayReserveTimeout bounds how long we wait to connect to and
+        // reserve a slot on a single relay candidate.
+        relayReserveTimeout = 30 * time.Second
+
+        // relayRefreshMargin is how long before a reservation expires
+        // that we attempt to renew it.
+        relayRefreshMargin = 2 * time.Minute
+
+        // roomRendezvousPrefix namespaces our rooms within the shared DHT
+        // and gossipsub so we don't collide with other libp2p applications
+        // advertising the same human-readable room names.
+        roomRendezvousPrefix = "securelink/room/"
+
+        // roomDiscoveryInterval is how often we re-query the DHT for peers
+        // advertising a room we've joined.
+        roomDiscoveryInterval = 20 * time.Second
+
+        // relayRendezvousPrefix namespaces circuit-relay reachability
+        // advertisements within the shared DHT, keyed per-peer below so a
+        // lookup for one peer's relays can never return another peer's.
+        relayRendezvousPrefix = "securelink/relay/"
+
+        // identityConfigDirName is the application subdirectory created under
+        // os.UserConfigDir() to hold on-disk identity stores.
+        identityConfigDirName = "securelink"
+
+        // scrypt parameters for the passphrase-encrypted identity store.
+        // These match the scrypt-recommended interactive work factor as of
+        // this writing; bump scryptN if hardware moves on.
+        scryptN        = 1 << 15
+        scryptR        = 8
+        scryptP        = 1
+        scryptKeyLen   = chacha20poly1305.KeySize
+        scryptSaltSize = 16
+)
+
+// Message types between browser and helper
+type Message struct {
+        Type   string          `json:"type"`
+        Data   json.RawMessage `json:"data,omitempty"`
+        To     string          `json:"to,omitempty"`
+        CallID string          `json:"callId,omitempty"`
+}
+
+// roomPeer is a peer we've surfaced to the browser as part of a room
+// roster, along with the capabilities it most recently advertised.
+type roomPeer struct {
+        id           peer.ID
+        capabilities []string
+}
+
+// Room tracks the state needed to keep a single rendezvous room alive:
+// the pubsub topic peers broadcast presence over, and the roster of
+// peers already surfaced to the browser so it can present a de-duped
+// room roster instead of repeat peer-discovered events.
+type Room struct {
+        id         string
+        rendezvous string
+        topic      *pubsub.Topic
+        sub        *pubsub.Subscription
+        cancel     context.CancelFunc
+        rosterLock sync.Mutex
+        roster     map[peer.ID]*roomPeer
+}
+
+// roomPresence is the payload each room member periodically publishes
+// to the room's gossipsub topic to advertise what kind of media it can
+// send, so newly-joined peers learn capabilities without a signaling
+// round-trip.
+type roomPresence struct {
+        Capabilities []string `json:"capabilities"`
+}
+
+// Call is one logical conversation with a single remote peer: its own
+// browser-facing WebRTC peer connection, the browser-facing tracks
+// created for RTP received on it, and whatever currently carries RTP
+// to/from the remote peer. Calls are keyed by call ID on Helper.calls,
+// so one helper can hold several simultaneous calls to different (or
+// even the same) remote peers.
+type Call struct {
+        id           string
+        remotePeerID string
+
+        // WebRTC peer connection with the browser for this call.
+        browserPC *webrtc.PeerConnection
+        // localTracks holds the browser-facing track we've created for
+        // each SSRC we've seen from the remote peer, so repeat packets on
+        // an already-known SSRC reuse the existing track instead of
+        // tripping over a duplicate AddTrack.
+        localTracks map[webrtc.SSRC]*webrtc.TrackLocalStaticRTP
+        pcLock      sync.Mutex
+
+        // renegotiating and renegotiatePending serialize browser
+        // renegotiation: a call's audio and video SSRCs typically arrive
+        // within milliseconds of each other, and each would otherwise
+        // trigger its own concurrent CreateOffer/SetLocalDescription
+        // round on browserPC. scheduleRenegotiate coalesces these into a
+        // single in-flight renegotiation with at most one more queued
+        // behind it.
+        renegotiating      bool
+        renegotiatePending bool
+
+        // RTP forwarding to the remote peer: either a framed libp2p
+        // stream shared with the peer's other calls (streamRTPConn) or,
+        // when the remote peer has no other reachable transport, a
+        // direct WebRTC data channel negotiated over
+        // WebRTCSignalProtocol (dataChannelRTPConn).
+        rtpConn    rtpConn
+        streamLock sync.Mutex
+
+        // privatePC is the direct WebRTC connection negotiated over
+        // WebRTCSignalProtocol for this call, if any; its "rtp" data
+        // channel becomes rtpConn once open. Kept around so Start can
+        // close it on shutdown.
+        privatePC     *webrtc.PeerConnection
+        privatePCLock sync.Mutex
+
+        // fanOut, when enabled, retransmits every RTP packet received
+        // from this call's remote peer out to every other active call's
+        // remote peer, turning the helper into a small SFU for group
+        // calls. See Helper.fanOutRTP.
+        fanOut     bool
+        fanOutLock sync.Mutex
+}
+
+// peerConn is a single libp2p RTPProtocol stream shared by every Call to
+// the same remote peer, so a second call to a peer we're already
+// talking to multiplexes onto the existing stream instead of opening a
+// new one. Frames are tagged with a call ID (see writeFrame/readFrame)
+// so pumpInboundRTP can demultiplex them back to the right Call.
+type peerConn struct {
+        peerID    peer.ID
+        stream    network.Stream
+        writeLock sync.Mutex
+}
+
+func (p *peerConn) writeFrame(callID string, payload []byte) error {
+        p.writeLock.Lock()
+        defer p.writeLock.Unlock()
+        return writeFrame(p.stream, callID, payload)
+}
+
+func (p *peerConn) Close() error { return p.stream.Close() }
+
+// Helper manages the P2P WebRTC relay
+type Helper struct {
+        ctx       context.Context
+        cancel    context.CancelFunc
+        host      host.Host
+        dht       *dht.IpfsDHT
+
+        // WebSocket for browser communication
+        wsConn    *websocket.Conn
+        wsLock    sync.Mutex
+        wsUpgrader websocket.Upgrader
+
+        // iceServers configures the browser-facing peer connection and
+        // any private WebRTC connection negotiated with a remote peer;
+        // see loadICEServers and defaultICEServers.
+        iceServers []webrtc.ICEServer
+
+        // calls holds every active Call, keyed by call ID.
+        calls     map[string]*Call
+        callsLock sync.Mutex
+
+        // peerConns holds the shared libp2p RTP stream to each remote
+        // peer we're currently talking to, keyed by peer ID, so multiple
+        // calls to the same peer multiplex onto one stream. See
+        // getOrCreatePeerConn.
+        peerConns     map[peer.ID]*peerConn
+        peerConnsLock sync.Mutex
+
+        // Circuit relay v2 reservations, keyed by relay peer ID, used as
+        // a fallback path when a peer can't be dialed directly (e.g. it's
+        // behind a symmetric NAT).
+        relays      map[peer.ID]*circuitv2client.Reservation
+        relaysLock  sync.Mutex
+        relayNodes  []peer.AddrInfo
+        relayServer bool
+
+        // relayAddrs holds the /p2p-circuit addresses derived from our
+        // current relay reservations. addrsFactory (set on the libp2p
+        // host in NewHelper) folds these into host.Addrs() so that DHT
+        // lookups of our own peer record surface them to remote peers;
+        // updateRelayAddrs keeps it current as reservations come and go.
+        // relayAdvertiseOnce additionally publishes our reachability under
+        // relayRendezvous so a peer that hasn't yet seen a fresh DHT
+        // record for us can still find a relay path in.
+        relayAddrs         *atomic.Value // []multiaddr.Multiaddr
+        relayAdvertiseOnce sync.Once
+
+        // Rendezvous discovery: advertising and finding peers in a room
+        // rides on the DHT, while room presence (capabilities) and
+        // roster updates ride on a per-room gossipsub topic.
+        discovery *routing.RoutingDiscovery
+        pubsub    *pubsub.PubSub
+        rooms     map[string]*Room
+        roomsLock sync.Mutex
+}
+
+// NewHelper creates a new helper instance. If relayServer is true, this
+// host also advertises itself as a circuit v2 relay for other peers. If
+// relayNodes is empty, the bootstrap peers double as the default relay
+// candidates. If iceServers is empty, defaultICEServers is used.
+// identityStore determines where the peer's private key lives and how
+// it's protected at rest.
+func NewHelper(ctx context.Context, relayServer bool, relayNodes []peer.AddrInfo, iceServers []webrtc.ICEServer, identityStore IdentityStore) (*Helper, error) {
+        ctx, cancel := context.WithCancel(ctx)
+
+        // Load or create persistent identity
+        privKey, err := loadOrCreateIdentity(identityStore)
+        if err != nil {
+                cancel()
+                return nil, fmt.Errorf("failed to load identity: %w", err)
+        }
+
+        // Create connection manager
+        connManager, err := connmgr.NewConnManager(100, 400, connmgr.WithGracePeriod(time.Minute))
+        if err != nil {
+                cancel()
+                return nil, err
+        }
+
+        // relayAddrs is populated by updateRelayAddrs once reservations
+        // come in; addrsFactory folds its current contents into every
+        // address we advertise, which is what lets other peers' DHT
+        // lookups of us return a dialable /p2p-circuit address.
+        relayAddrs := new(atomic.Value)
+        relayAddrs.Store([]multiaddr.Multiaddr{})
+        addrsFactory := func(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+                extra, _ := relayAddrs.Load().([]multiaddr.Multiaddr)
+                return append(addrs, extra...)
+        }
+
+        // Create libp2p host with WebRTC-direct transport
+        opts := []libp2p.Option{
+                libp2p.Identity(privKey),
+                libp2p.ListenAddrStrings(
+                        "/ip4/0.0.0.0/tcp/0",
+                        "/ip6/::/tcp/0",
+                        "/ip4/0.0.0.0/udp/0/quic-v1",
+                        "/ip6/::/udp/0/quic-v1",
+                ),
+                // Add WebRTC-direct transport
+                libp2p.Transport(webrtc_direct.New),
+                libp2p.Security(libp2ptls.ID, libp2ptls.New),
+                libp2p.Security(noise.ID, noise.New),
+                libp2p.ConnectionManager(connManager),
+                libp2p.NATPortMap(),
+                libp2p.EnableNATService(),
+                libp2p.EnableHolePunching(),
+                libp2p.EnableRelay(),
+                libp2p.AddrsFactory(addrsFactory),
+        }
+        if relayServer {
+                opts = append(opts, libp2p.EnableRelayService())
+        }
+
+        h, err := libp2p.New(opts...)
+        if err != nil {
+                cancel()
+                return nil, fmt.Errorf("failed to create libp2p host: %w", err)
+        }
+
+        // Create Kademlia DHT
+        kadDHT, err := dht.New(ctx, h, dht.Mode(dht.ModeAutoServer))
+        if err != nil {
+                h.Close()
+                cancel()
+                return nil, err
+        }
+
+        // Bootstrap DHT
+        if err := kadDHT.Bootstrap(ctx); err != nil {
+                h.Close()
+                cancel()
+                return nil, err
+        }
+
+        // Connect to bootstrap nodes
+        go bootstrapConnect(ctx, h)
+
+        if len(relayNodes) == 0 {
+                relayNodes = defaultRelayNodes()
+        }
+
+        if len(iceServers) == 0 {
+                iceServers = defaultICEServers()
+        }
+
+        // Rendezvous discovery rides on top of the DHT; gossipsub gives
+        // rooms a lightweight broadcast channel for presence without a
+        // per-pair stream.
+        routingDiscovery := routing.NewRoutingDiscovery(kadDHT)
+        gossipSub, err := pubsub.NewGossipSub(ctx, h)
+        if err != nil {
+                h.Close()
+                cancel()
+                return nil, fmt.Errorf("failed to create pubsub: %w", err)
+        }
+
+        helper := &Helper{
+                ctx:    ctx,
+                cancel: cancel,
+                host:   h,
+                dht:    kadDHT,
+                wsUpgrader: websocket.Upgrader{
+                        CheckOrigin: func(r *http.Request) bool { return true },
+                },
+                calls:       make(map[string]*Call),
+                peerConns:   make(map[peer.ID]*peerConn),
+                relays:      make(map[peer.ID]*circuitv2client.Reservation),
+                relayNodes:  relayNodes,
+                relayServer: relayServer,
+                relayAddrs:  relayAddrs,
+                discovery:   routingDiscovery,
+                pubsub:      gossipSub,
+                rooms:       make(map[string]*Room),
+                iceServers:  iceServers,
+        }
+
+        // Set RTP stream handler
+        h.SetStreamHandler(protocol.ID(RTPProtocol), helper.handleRTPStream)
+
+        // Set private WebRTC signaling handler, used when a remote peer
+        // advertises only a /webrtc address and so has no transport we
+        // can open an RTPProtocol stream over.
+        h.SetStreamHandler(protocol.ID(WebRTCSignalProtocol), helper.handleWebRTCSignalStream)
+
+        // Keep circuit v2 reservations on a handful of relays so peers
+        // behind a symmetric NAT can still reach us.
+        go helper.maintainRelays(ctx)
+
+        return helper, nil
+}
+
+// IdentityStore loads and persists the Ed25519 private key that backs
+// our PeerID. Implementations differ in where the key material lives
+// and how it's protected at rest, but loadOrCreateIdentity treats them
+// uniformly: Load returning an os.IsNotExist-compatible error means
+// "no identity yet, generate one", and any other error is fatal rather
+// than a cue to silently mint a new PeerID (which would orphan every
+// contact that already has the old one saved).
+type IdentityStore interface {
+        Load() (crypto.PrivKey, error)
+        Save(crypto.PrivKey) error
+}
+
+// loadOrCreateIdentity loads the persistent peer identity from store,
+// generating and saving a new one only if store reports that none
+// exists yet.
+func loadOrCreateIdentity(store IdentityStore) (crypto.PrivKey, error) {
+        privKey, err := store.Load()
+        switch {
+        case err == nil:
+                log.Println("✓ Loaded existing peer identity")
+                return privKey, nil
+        case os.IsNotExist(err):
+                // Fall through and generate a fresh identity.
+        default:
+                return nil, fmt.Errorf("failed to load peer identity: %w", err)
+        }
+
+        privKey, _, err = crypto.GenerateKeyPairWithReader(crypto.Ed25519, 2048, rand.Reader)
+        if err != nil {
+                return nil, err
+        }
+
+        if err := store.Save(privKey); err != nil {
+                return nil, fmt.Errorf("failed to save new peer identity: %w", err)
+        }
+
+        log.Println("✓ Generated and saved new peer identity")
+        return privKey, nil
+}
+
+// newIdentityStore resolves the --identity flag value into a concrete
+// IdentityStore: "default" (or "") for a plaintext key under the OS
+// config dir, "encrypted" for a passphrase-protected keyfile in the
+// same place, or "agent:<unix socket path>" to read the key from an
+// external agent (e.g. one backed by a hardware security key) without
+// it ever touching disk here.
+func newIdentityStore(spec string) (IdentityStore, error) {
+        switch {
+        case spec == "" || spec == "default":
+                return newConfigDirIdentityStore()
+        case spec == "encrypted":
+                passphrase, err := promptPassphrase("Enter passphrase for encrypted identity: ")
+                if err != nil {
+                        return nil, err
+                }
+                return newEncryptedIdentityStore(passphrase)
+        case strings.HasPrefix(spec, "agent:"):
+                return &agentIdentityStore{socketPath: strings.TrimPrefix(spec, "agent:")}, nil
+        default:
+                return nil, fmt.Errorf("unknown --identity value %q (want \"default\", \"encrypted\", or \"agent:<socket path>\")", spec)
+        }
+}
+
+// promptPassphrase prompts on stderr and reads a passphrase from the
+// terminal without echoing it.
+func promptPassphrase(prompt string) ([]byte, error) {
+        fmt.Fprint(os.Stderr, prompt)
+        passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+        fmt.Fprintln(os.Stderr)
+        if err != nil {
+                return nil, fmt.Errorf("failed to read passphrase: %w", err)
+        }
+        if len(passphrase) == 0 {
+                return nil, fmt.Errorf("passphrase must not be empty")
+        }
+        return passphrase, nil
+}
+
+// identityConfigPath returns path/name under the OS-appropriate config
+// directory, creating the application subdirectory if needed.
+func identityConfigPath(name string) (string, error) {
+        dir, err := os.UserConfigDir()
+        if err != nil {
+                return "", fmt.Errorf("failed to resolve config dir: %w", err)
+        }
+
+        appDir := filepath.Join(dir, identityConfigDirName)
+        if err := os.MkdirAll(appDir, 0700); err != nil {
+                return "", fmt.Errorf("failed to create config dir: %w", err)
+        }
+
+        return filepath.Join(appDir, name), nil
+}
+
+// atomicWriteFile writes data to a temp file alongside path and renames
+// it into place, so a crash mid-write can't leave a corrupted identity
+// file where a good one used to be.
+func atomicWriteFile(path string, data []byte, perm os.FileMode) error {
+        tmp := path + ".tmp"
+        if err := os.WriteFile(tmp, data, perm); err != nil {
+                return err
+        }
+        return os.Rename(tmp, path)
+}
+
+// configDirIdentityStore keeps an unencrypted marshaled private key
+// under the OS-appropriate config directory. It's the default store:
+// a step up from a key file dropped in the CWD, but still meant for a
+// single-user machine where the config dir itself is the trust boundary.
+type configDirIdentityStore struct {
+        path string
+}
+
+func newConfigDirIdentityStore() (*configDirIdentityStore, error) {
+        path, err := identityConfigPath("identity.key")
+        if err != nil {
+                return nil, err
+        }
+        return &configDirIdentityStore{path: path}, nil
+}
+
+func (s *configDirIdentityStore) Load() (crypto.PrivKey, error) {
+        data, err := os.ReadFile(s.path)
+        if err != nil {
+                return nil, err
+        }
+
+        privKey, err := crypto.UnmarshalPrivateKey(data)
+        if err != nil {
+                backupPath := s.path + ".bak"
+                if backupErr := os.Rename(s.path, backupPath); backupErr != nil {
+                        log.Printf("⚠ Failed to back up unreadable identity file: %v", backupErr)
+                } else {
+                        log.Printf("⚠ Backed up unreadable identity file to %s", backupPath)
+                }
+                return nil, fmt.Errorf("failed to unmarshal identity key: %w", err)
+        }
+
+        return privKey, nil
+}
+
+func (s *configDirIdentityStore) Save(privKey crypto.PrivKey) error {
+        data, err := crypto.MarshalPrivateKey(privKey)
+        if err != nil {
+                return err
+        }
+        return atomicWriteFile(s.path, data, 0600)
+}
+
+// encryptedIdentityStore protects the marshaled private key at rest
+// with a passphrase, via scrypt key derivation and XChaCha20-Poly1305.
+// On-disk layout is salt || nonce || ciphertext.
+type encryptedIdentityStore struct {
+        path       string
+        passphrase []byte
+}
+
+func newEncryptedIdentityStore(passphrase []byte) (*encryptedIdentityStore, error) {
+        path, err := identityConfigPath("identity.key.enc")
+        if err != nil {
+                return nil, err
+        }
+        return &encryptedIdentityStore{path: path, passphrase: passphrase}, nil
+}
+
+func (s *encryptedIdentityStore) Load() (crypto.PrivKey, error) {
+        blob, err := os.ReadFile(s.path)
+        if err != nil {
+                return nil, err
+        }
+
+        if len(blob) < scryptSaltSize+chacha20poly1305.NonceSizeX {
+                return nil, fmt.Errorf("encrypted identity file is truncated")
+        }
+        salt := blob[:scryptSaltSize]
+        nonce := blob[scryptSaltSize : scryptSaltSize+chacha20poly1305.NonceSizeX]
+        ciphertext := blob[scryptSaltSize+chacha20poly1305.NonceSizeX:]
+
+        aead, err := s.cipher(salt)
+        if err != nil {
+                return nil, err
+        }
+
+        plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+        if err != nil {
+                return nil, fmt.Errorf("failed to decrypt identity (wrong passphrase?): %w", err)
+        }
+
+        return crypto.UnmarshalPrivateKey(plaintext)
+}
+
+func (s *encryptedIdentityStore) Save(privKey crypto.PrivKey) error {
+        data, err := crypto.MarshalPrivateKey(privKey)
+        if err != nil {
+                return err
+        }
+
+        salt := make([]byte, scryptSaltSize)
+        if _, err := rand.Read(salt); err != nil {
+                return err
+        }
+
+        aead, err := s.cipher(salt)
+        if err != nil {
+                return err
+        }
+
+        nonce := make([]byte, chacha20poly1305.NonceSizeX)
+        if _, err := rand.Read(nonce); err != nil {
+                return err
+        }
+
+        ciphertext := aead.Seal(nil, nonce, data, nil)
+
+        blob := make([]byte, 0, len(salt)+len(nonce)+len(ciphertext))
+        blob = append(blob, salt...)
+        blob = append(blob, nonce...)
+        blob = append(blob, ciphertext...)
+
+        return atomicWriteFile(s.path, blob, 0600)
+}
+
+func (s *encryptedIdentityStore) cipher(salt []byte) (cipher.AEAD, error) {
+        key, err := scrypt.Key(s.passphrase, salt, scryptN, scryptR, scryptP, scryptKeyLen)
+        if err != nil {
+                return nil, fmt.Errorf("failed to derive key: %w", err)
+        }
+        return chacha20poly1305.NewX(key)
+}
+
+// agentIdentityStore reads the private key from an external agent over
+// a Unix socket, so hardware-key-backed setups never need to write key
+// material to this machine's disk at all. It's read-only: the agent
+// owns key generation and rotation.
+type agentIdentityStore struct {
+        socketPath string
+}
+
+func (s *agentIdentityStore) Load() (crypto.PrivKey, error) {
+        conn, err := net.Dial("unix", s.socketPath)
+        if err != nil {
+                return nil, fmt.Errorf("failed to reach identity agent at %s: %w", s.socketPath, err)
+        }
+        defer conn.Close()
+
+        if _, err := conn.Write([]byte("GET_IDENTITY\n")); err != nil {
+                return nil, fmt.Errorf("failed to request identity from agent: %w", err)
+        }
+
+        data, err := io.ReadAll(conn)
+        if err != nil {
+                return nil, fmt.Errorf("failed to read identity from agent: %w", err)
+        }
+
+        return crypto.UnmarshalPrivateKey(data)
+}
+
+func (s *agentIdentityStore) Save(crypto.PrivKey) error {
+        return fmt.Errorf("identity agent store is read-only; manage the key via the external agent")
+}
+
+// bootstrapPeerAddrs are the well-known IPFS bootstrap nodes, reused both
+// to seed the DHT and, by default, as circuit v2 relay candidates.
+var bootstrapPeerAddrs = []string{
+        "/dnsaddr/bootstrap.libp2p.io/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN",
+        "/dnsaddr/bootstrap.libp2p.io/p2p/QmQCU2EcMqAqQPR2i9bChDtGNJchTbq5TbXJJ16u19uLTa",
+        "/dnsaddr/bootstrap.libp2p.io/p2p/QmbLHAnMoJPWSCR5Zhtx6BHJX9KiKNN6tpvbUcqanj75Nb",
+        "/dnsaddr/bootstrap.libp2p.io/p2p/QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt",
+}
+
+// parseAddrInfos parses a list of multiaddr strings into AddrInfos,
+// skipping (and logging) any that don't parse or don't carry a /p2p
+// peer ID component.
+func parseAddrInfos(addrs []string) []peer.AddrInfo {
+        infos := make([]peer.AddrInfo, 0, len(addrs))
+        for _, addr := range addrs {
+                ma, err := multiaddr.NewMultiaddr(addr)
+                if err != nil {
+                        log.Printf("⚠ Skipping unparsable address %q: %v", addr, err)
+                        continue
+                }
+
+                peerInfo, err := peer.AddrInfoFromP2pAddr(ma)
+                if err != nil {
+                        log.Printf("⚠ Skipping address without peer ID %q: %v", addr, err)
+                        continue
+                }
+
+                infos = append(infos, *peerInfo)
+        }
+        return infos
+}
+
+// defaultRelayNodes returns the bootstrap peers as circuit v2 relay
+// candidates, used when no --relay-nodes were supplied on the command
+// line.
+func defaultRelayNodes() []peer.AddrInfo {
+        return parseAddrInfos(bootstrapPeerAddrs)
+}
+
+// ICEServerConfig is the on-disk representation of a single ICE server
+// entry in a --ice-servers file; loadICEServers converts each of these
+// to a webrtc.ICEServer.
+type ICEServerConfig struct {
+        URLs       []string `json:"urls"`
+        Username   string   `json:"username,omitempty"`
+        Credential string   `json:"credential,omitempty"`
+}
+
+// defaultICEServers is used when no --ice-servers file is supplied. A
+// public STUN server is enough for peers that aren't behind a
+// restrictive NAT, but reaching one that needs a TURN relay requires
+// supplying real TURN credentials via --ice-servers.
+func defaultICEServers() []webrtc.ICEServer {
+        return []webrtc.ICEServer{
+                {URLs: []string{"stun:stun.l.google.com:19302"}},
+        }
+}
+
+// loadICEServers reads a JSON file containing a list of ICEServerConfig
+// entries (STUN and/or TURN, with credentials for TURN) and converts
+// them to the ICEServers the browser-facing and private WebRTC peer
+// connections are configured with.
+func loadICEServers(path string) ([]webrtc.ICEServer, error) {
+        data, err := os.ReadFile(path)
+        if err != nil {
+                return nil, err
+        }
+
+        var configs []ICEServerConfig
+        if err := json.Unmarshal(data, &configs); err != nil {
+                return nil, fmt.Errorf("invalid ICE server config: %w", err)
+        }
+
+        servers := make([]webrtc.ICEServer, 0, len(configs))
+        for _, c := range configs {
+                servers = append(servers, webrtc.ICEServer{
+                        URLs:       c.URLs,
+                        Username:   c.Username,
+                        Credential: c.Credential,
+                })
+        }
+        return servers, nil
+}
+
+// bootstrapConnect connects to IPFS bootstrap nodes
+func bootstrapConnect(ctx context.Context, h host.Host) {
+        for _, peerInfo := range parseAddrInfos(bootstrapPeerAddrs) {
+                if err := h.Connect(ctx, peerInfo); err != nil {
+                        log.Printf("⚠ Failed to connect to bootstrap: %v", err)
+                } else {
+                        log.Printf("✓ Connected to bootstrap: %s", peerInfo.ID.ShortString())
+                }
+        }
+}
+
+// maintainRelays periodically reserves (and renews) slots on a handful
+// of relays so that peers behind a symmetric NAT can still reach us via
+// a /p2p-circuit address.
+func (h *Helper) maintainRelays(ctx context.Context) {
+        h.ensureRelays(ctx)
+
+        ticker := time.NewTicker(relayRefreshMargin / 2)
+        defer ticker.Stop()
+
+        for {
+                select {
+                case <-ctx.Done():
+                        return
+                case <-ticker.C:
+                        h.ensureRelays(ctx)
+                }
+        }
+}
+
+// ensureRelays drops reservations that have expired or are about to,
+// then tries relay candidates in order until we're holding
+// desiredRelayCount live reservations. Any change to the reservation set
+// is reflected in our advertised addresses via updateRelayAddrs.
+func (h *Helper) ensureRelays(ctx context.Context) {
+        h.relaysLock.Lock()
+        dropped := false
+        for id, rsvp := range h.relays {
+                if time.Until(rsvp.Expiration) < relayRefreshMargin {
+                        delete(h.relays, id)
+                        dropped = true
+                }
+        }
+        have := len(h.relays)
+        h.relaysLock.Unlock()
+
+        if dropped {
+                h.updateRelayAddrs()
+        }
+
+        if have >= desiredRelayCount {
+                return
+        }
+
+        for _, candidate := range h.relayNodes {
+                if candidate.ID == h.host.ID() {
+                        continue
+                }
+
+                h.relaysLock.Lock()
+                _, already := h.relays[candidate.ID]
+                h.relaysLock.Unlock()
+                if already {
+                        continue
+                }
+
+                reserveCtx, cancel := context.WithTimeout(ctx, relayReserveTimeout)
+                rsvp, err := circuitv2client.Reserve(reserveCtx, h.host, candidate)
+                cancel()
+                if err != nil {
+                        log.Printf("⚠ Failed to reserve relay slot on %s: %v", candidate.ID.ShortString(), err)
+                        continue
+                }
+
+                log.Printf("✓ Reserved relay slot on %s (expires %s)", candidate.ID.ShortString(), rsvp.Expiration)
+
+                h.relaysLock.Lock()
+                h.relays[candidate.ID] = rsvp
+                have = len(h.relays)
+                h.relaysLock.Unlock()
+
+                h.updateRelayAddrs()
+                h.advertiseRelay()
+
+                if have >= desiredRelayCount {
+                        return
+                }
+        }
+}
+
+// updateRelayAddrs rebuilds the /p2p-circuit addresses we advertise for
+// ourselves from the current reservation set and stores them where
+// addrsFactory (installed on the host in NewHelper) picks them up, so
+// host.Addrs() — and therefore our DHT peer record — includes a
+// dialable relay address for as long as we hold one.
+func (h *Helper) updateRelayAddrs() {
+        h.relaysLock.Lock()
+        addrs := make([]multiaddr.Multiaddr, 0, len(h.relays))
+        for relayID, rsvp := range h.relays {
+                addr, err := relayCircuitAddr(relayID, rsvp.Addrs, h.host.ID())
+                if err != nil {
+                        continue
+                }
+                addrs = append(addrs, addr)
+        }
+        h.relaysLock.Unlock()
+
+        h.relayAddrs.Store(addrs)
+}
+
+// advertiseRelay publishes our reachability under relayRendezvous into
+// the DHT the first time we hold a relay reservation, so a peer trying
+// to reach us via connectViaRelay can find a circuit path even before
+// it has fetched a fresh DHT record for our peer ID.
+func (h *Helper) advertiseRelay() {
+        h.relayAdvertiseOnce.Do(func() {
+                dutil.Advertise(h.ctx, h.discovery, relayRendezvous(h.host.ID()))
+                log.Printf("✓ Advertising relay reachability as %s", relayRendezvous(h.host.ID()))
+        })
+}
+
+// relayRendezvous returns the rendezvous string a peer advertises its
+// circuit relay reachability under, namespaced by its own peer ID so a
+// lookup for one peer's relays can never return another's.
+func relayRendezvous(id peer.ID) string {
+        return relayRendezvousPrefix + id.String()
+}
+
+// createBrowserPeerConnection creates the browser-facing WebRTC peer
+// connection for this call.
+func (call *Call) createBrowserPeerConnection(h *Helper) error {
+        call.pcLock.Lock()
+        defer call.pcLock.Unlock()
+
+        // Create media engine
+        m := &webrtc.MediaEngine{}
+        if err := m.RegisterDefaultCodecs(); err != nil {
+                return err
+        }
+
+        // Create interceptor registry
+        i := &interceptor.Registry{}
+        if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
+                return err
+        }
+
+        // Create API with media engine
+        api := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i))
+
+        // Create peer connection, using the configured STUN/TURN servers
+        // so a browser that isn't on the same LAN as the helper can still
+        // gather a usable ICE candidate.
+        config := webrtc.Configuration{
+                ICEServers: h.iceServers,
+        }
+
+        pc, err := api.NewPeerConnection(config)
+        if err != nil {
+                return err
+        }
+
+        // Handle incoming tracks from browser
+        pc.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+                log.Printf("← Received track from browser for call %s: %s", call.id, track.Kind())
+
+                // Forward RTP packets to remote peer via libp2p
+                go call.forwardRTPToLibp2p(h, track)
+        })
+
+        pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+                log.Printf("Browser ICE connection state (call %s): %s", call.id, state)
+        })
+
+        call.browserPC = pc
+        call.localTracks = make(map[webrtc.SSRC]*webrtc.TrackLocalStaticRTP)
+        return nil
+}
+
+// rtpConn is the narrow interface the RTP forwarding loops need from
+// whatever transport currently carries RTP to/from the remote peer: a
+// length-prefixed libp2p stream (streamRTPConn), or, when the remote
+// peer has no other reachable transport, a direct WebRTC data channel
+// negotiated via WebRTCSignalProtocol (dataChannelRTPConn).
+type rtpConn interface {
+        io.Closer
+        writeRTP(payload []byte) error
+}
+
+// streamRTPConn carries RTP as length-prefixed, call-ID-tagged frames
+// over a peerConn's stream, which may be shared with other calls to the
+// same remote peer; see writeFrame/readFrame. Close is a no-op since the
+// underlying stream outlives any single call and is closed by the
+// Helper when the peer itself disconnects.
+type streamRTPConn struct {
+        peer   *peerConn
+        callID string
+}
+
+func (c *streamRTPConn) writeRTP(payload []byte) error { return c.peer.writeFrame(c.callID, payload) }
+func (c *streamRTPConn) Close() error                  { return nil }
+
+// dataChannelRTPConn carries RTP over a pion WebRTC data channel
+// negotiated out-of-band via WebRTCSignalProtocol. Each Send is already
+// a single discrete message, so unlike streamRTPConn this needs no
+// length prefix.
+type dataChannelRTPConn struct {
+        dc *webrtc.DataChannel
+}
+
+func (c *dataChannelRTPConn) writeRTP(payload []byte) error { return c.dc.Send(payload) }
+func (c *dataChannelRTPConn) Close() error                  { return c.dc.Close() }
+
+// forwardRTPToLibp2p reads RTP from the browser and forwards it to
+// whatever currently carries RTP to this call's remote peer.
+func (call *Call) forwardRTPToLibp2p(h *Helper, track *webrtc.TrackRemote) {
+        defer log.Println("RTP forwarding stopped")
+
+        for {
+                // Read RTP packet from browser
+                pkt, _, err := track.ReadRTP()
+                if err != nil {
+                        if err != io.EOF {
+                                log.Printf("Error reading RTP: %v", err)
+                        }
+                        return
+                }
+
+                call.streamLock.Lock()
+                conn := call.rtpConn
+                call.streamLock.Unlock()
+
+                if conn == nil {
+                        continue
+                }
+
+                // Marshal RTP packet
+                data, err := pkt.Marshal()
+                if err != nil {
+                        log.Printf("Error marshaling RTP: %v", err)
+                        continue
+                }
+
+                if err := conn.writeRTP(data); err != nil {
+                        log.Printf("Error forwarding RTP: %v", err)
+                        continue
+                }
+        }
+}
+
+// writeFrame writes a single length-prefixed, call-ID-tagged frame: a
+// 2-byte big-endian call ID length, the call ID itself, a 4-byte
+// big-endian payload length, then the payload. Tagging every frame with
+// a call ID lets one libp2p stream to a peer (see peerConn) multiplex
+// RTP for several simultaneous calls to that peer.
+func writeFrame(w io.Writer, callID string, payload []byte) error {
+        if len(payload) > rtpFrameMaxSize {
+                return fmt.Errorf("RTP packet too large: %d bytes exceeds %d byte limit", len(payload), rtpFrameMaxSize)
+        }
+        if len(callID) > math.MaxUint16 {
+                return fmt.Errorf("call ID too long: %d bytes", len(callID))
+        }
+
+        var idHeader [2]byte
+        binary.BigEndian.PutUint16(idHeader[:], uint16(len(callID)))
+        if _, err := w.Write(idHeader[:]); err != nil {
+                return err
+        }
+        if _, err := io.WriteString(w, callID); err != nil {
+                return err
+        }
+
+        var header [4]byte
+        binary.BigEndian.PutUint32(header[:], uint32(len(payload)))
+        if _, err := w.Write(header[:]); err != nil {
+                return err
+        }
+        _, err := w.Write(payload)
+        return err
+}
+
+// readFrame reads a single frame written by writeFrame, rejecting a
+// payload over rtpFrameMaxSize before allocating a buffer for it, and
+// returns the call ID it was tagged with alongside the payload.
+func readFrame(r io.Reader) (callID string, payload []byte, err error) {
+        var idHeader [2]byte
+        if _, err := io.ReadFull(r, idHeader[:]); err != nil {
+                return "", nil, err
+        }
+        idLen := binary.BigEndian.Uint16(idHeader[:])
+
+        idBytes := make([]byte, idLen)
+        if _, err := io.ReadFull(r, idBytes); err != nil {
+                return "", nil, err
+        }
+
+        var header [4]byte
+        if _, err := io.ReadFull(r, header[:]); err != nil {
+                return "", nil, err
+        }
+
+        size := binary.BigEndian.Uint32(header[:])
+        if size > rtpFrameMaxSize {
+                return "", nil, fmt.Errorf("frame size %d exceeds %d byte limit", size, rtpFrameMaxSize)
+        }
+
+        payload = make([]byte, size)
+        if _, err := io.ReadFull(r, payload); err != nil {
+                return "", nil, err
+        }
+        return string(idBytes), payload, nil
+}
+
+// getOrCreatePeerConn returns the shared libp2p RTP stream to peerID,
+// opening a new RTPProtocol stream and starting its inbound read loop
+// on first use, so a second call to a peer we're already talking to
+// multiplexes onto the existing stream instead of opening another one.
+func (h *Helper) getOrCreatePeerConn(ctx context.Context, peerID peer.ID) (*peerConn, error) {
+        h.peerConnsLock.Lock()
+        if existing, ok := h.peerConns[peerID]; ok {
+                h.peerConnsLock.Unlock()
+                return existing, nil
+        }
+        h.peerConnsLock.Unlock()
+
+        stream, err := h.host.NewStream(ctx, peerID, protocol.ID(RTPProtocol))
+        if err != nil {
+                return nil, err
+        }
+
+        pc := &peerConn{peerID: peerID, stream: stream}
+
+        h.peerConnsLock.Lock()
+        if existing, ok := h.peerConns[peerID]; ok {
+                h.peerConnsLock.Unlock()
+                stream.Close()
+                return existing, nil
+        }
+        h.peerConns[peerID] = pc
+        h.peerConnsLock.Unlock()
+
+        go h.pumpInboundRTP(pc)
+        return pc, nil
+}
+
+// handleRTPStream handles an incoming RTP stream opened by a remote
+// peer, registering it as that peer's shared peerConn before pumping
+// its frames.
+func (h *Helper) handleRTPStream(stream network.Stream) {
+        remotePeer := stream.Conn().RemotePeer()
+        log.Printf("← Incoming RTP stream from %s", remotePeer.ShortString())
+
+        pc := &peerConn{peerID: remotePeer, stream: stream}
+        h.peerConnsLock.Lock()
+        h.peerConns[remotePeer] = pc
+        h.peerConnsLock.Unlock()
+
+        defer func() {
+                h.peerConnsLock.Lock()
+                if h.peerConns[remotePeer] == pc {
+                        delete(h.peerConns, remotePeer)
+                }
+                h.peerConnsLock.Unlock()
+                stream.Close()
+        }()
+
+        h.pumpInboundRTP(pc)
+}
+
+// pumpInboundRTP reads framed RTP packets off a peer's shared stream
+// and dispatches each to the Call named by its call ID, creating one
+// (and notifying the browser) the first time we see a call ID the
+// remote peer originated that we don't already know about.
+func (h *Helper) pumpInboundRTP(pc *peerConn) {
+        for {
+                callID, payload, err := readFrame(pc.stream)
+                if err != nil {
+                        if err != io.EOF {
+                                log.Printf("Error reading from stream to %s: %v", pc.peerID.ShortString(), err)
+                        }
+                        return
+                }
+
+                call := h.findOrCreateCallForInboundRTP(pc, callID)
+                call.injectRemoteRTP(h, payload)
+        }
+}
+
+// findOrCreateCallForInboundRTP returns the Call for an inbound frame's
+// call ID, registering a new Call (and notifying the browser with a
+// peer-connected message carrying that call ID) the first time we see
+// it, since the remote peer may originate a call ID we haven't heard of
+// yet.
+func (h *Helper) findOrCreateCallForInboundRTP(pc *peerConn, callID string) *Call {
+        if call := h.getCall(callID); call != nil {
+                return call
+        }
+
+        call := &Call{id: callID, remotePeerID: pc.peerID.String()}
+        call.rtpConn = &streamRTPConn{peer: pc, callID: callID}
+
+        h.callsLock.Lock()
+        h.calls[callID] = call
+        h.callsLock.Unlock()
+
+        log.Printf("✓ New inbound call %s from %s", callID, pc.peerID.ShortString())
+
+        h.wsLock.Lock()
+        if h.wsConn != nil {
+                h.wsConn.WriteJSON(Message{
+                        Type:   "peer-connected",
+                        Data:   json.RawMessage(fmt.Sprintf(`"%s"`, pc.peerID.String())),
+                        CallID: callID,
+                })
+        }
+        h.wsLock.Unlock()
+
+        return call
+}
+
+// injectRemoteRTP unmarshals a single RTP packet received from this
+// call's remote peer, however it arrived (framed libp2p stream or
+// private WebRTC data channel), and writes it to the matching
+// browser-facing local track, creating one and triggering a
+// renegotiation on first sight of a new SSRC. If fan-out is enabled for
+// this call, the packet is also retransmitted to every other active
+// call's remote peer, turning the helper into a small SFU.
+func (call *Call) injectRemoteRTP(h *Helper, payload []byte) {
+        var pkt rtp.Packet
+        if err := pkt.Unmarshal(payload); err != nil {
+                log.Printf("Error unmarshaling RTP packet: %v", err)
+                return
+        }
+
+        track, created, err := call.getOrCreateLocalTrack(webrtc.SSRC(pkt.SSRC), pkt.PayloadType)
+        if err != nil {
+                log.Printf("Error preparing local track for ssrc %d: %v", pkt.SSRC, err)
+                return
+        }
+        if created {
+                log.Printf("✓ New inbound track ssrc=%d pt=%d, renegotiating with browser", pkt.SSRC, pkt.PayloadType)
+                call.scheduleRenegotiate(h)
+        }
+
+        if err := track.WriteRTP(&pkt); err != nil {
+                log.Printf("Error writing RTP to browser track: %v", err)
+        }
+
+        call.fanOutLock.Lock()
+        fanOut := call.fanOut
+        call.fanOutLock.Unlock()
+        if fanOut {
+                h.fanOutRTP(call, payload)
+        }
+}
+
+// fanOutRTP retransmits payload, just received from source's remote
+// peer, out to every other active call's remote peer. Used by calls
+// that have opted into fan-out mode so the helper can host a small
+// group call without a central media server.
+func (h *Helper) fanOutRTP(source *Call, payload []byte) {
+        h.callsLock.Lock()
+        others := make([]*Call, 0, len(h.calls))
+        for _, call := range h.calls {
+                if call != source {
+                        others = append(others, call)
+                }
+        }
+        h.callsLock.Unlock()
+
+        for _, call := range others {
+                call.streamLock.Lock()
+                conn := call.rtpConn
+                call.streamLock.Unlock()
+                if conn == nil {
+                        continue
+                }
+                if err := conn.writeRTP(payload); err != nil {
+                        log.Printf("⚠ Failed to fan out RTP to call %s: %v", call.id, err)
+                }
+        }
+}
+
+// getOrCreateLocalTrack returns the browser-facing track for ssrc on
+// this call, creating and adding it to the call's browser peer
+// connection on first sight. The caller must trigger a renegotiation
+// when created is true, since AddTrack after the initial offer/answer
+// exchange needs a new SDP round-trip before the browser will receive
+// media on it.
+func (call *Call) getOrCreateLocalTrack(ssrc webrtc.SSRC, payloadType uint8) (track *webrtc.TrackLocalStaticRTP, created bool, err error) {
+        call.pcLock.Lock()
+        defer call.pcLock.Unlock()
+
+        if call.browserPC == nil {
+                return nil, false, fmt.Errorf("no browser peer connection yet")
+        }
+
+        if existing, ok := call.localTracks[ssrc]; ok {
+                return existing, false, nil
+        }
+
+        id := fmt.Sprintf("remote-%d", ssrc)
+        track, err = webrtc.NewTrackLocalStaticRTP(codecForPayloadType(payloadType), id, id)
+        if err != nil {
+                return nil, false, err
+        }
+
+        if _, err := call.browserPC.AddTrack(track); err != nil {
+                return nil, false, err
+        }
+
+        call.localTracks[ssrc] = track
+        return track, true, nil
+}
+
+// codecForPayloadType maps a commonly-negotiated RTP payload type to the
+// codec capability the browser-facing track should advertise. We don't
+// have the original SDP at this layer, so this is a best-effort guess
+// from the payload type values browsers actually negotiate; unrecognized
+// payload types fall back to VP8, the common case for this app.
+func codecForPayloadType(payloadType uint8) webrtc.RTPCodecCapability {
+        switch payloadType {
+        case 111:
+                return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}
+        case 96, 97:
+                return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}
+        case 102, 127:
+                return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000}
+        default:
+                return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}
+        }
+}
+
+// scheduleRenegotiate queues a browser renegotiation for this call. If
+// one is already in flight, it just marks another as pending instead of
+// spawning a second goroutine, so concurrent SSRC arrivals can't race
+// CreateOffer/SetLocalDescription against each other on browserPC.
+func (call *Call) scheduleRenegotiate(h *Helper) {
+        call.pcLock.Lock()
+        if call.renegotiating {
+                call.renegotiatePending = true
+                call.pcLock.Unlock()
+                return
+        }
+        call.renegotiating = true
+        call.pcLock.Unlock()
+
+        go call.renegotiateLoop(h)
+}
+
+// renegotiateLoop runs renegotiateWithBrowser once, then again for every
+// renegotiation that was requested while it was running, until the call
+// is quiescent. This keeps at most one offer/answer round in flight for
+// this call at a time.
+func (call *Call) renegotiateLoop(h *Helper) {
+        for {
+                call.renegotiateWithBrowser(h)
+
+                call.pcLock.Lock()
+                if !call.renegotiatePending {
+                        call.renegotiating = false
+                        call.pcLock.Unlock()
+                        return
+                }
+                call.renegotiatePending = false
+                call.pcLock.Unlock()
+        }
+}
+
+// renegotiateWithBrowser asks the browser to renegotiate this call
+// after we've added a track to its browser peer connection outside the
+// initial offer/answer exchange. Since the browser made the original
+// offer, we become the offerer for this round; the browser is expected
+// to answer via a "renegotiate-answer" message carrying this call's ID.
+func (call *Call) renegotiateWithBrowser(h *Helper) {
+        call.pcLock.Lock()
+        pc := call.browserPC
+        call.pcLock.Unlock()
+        if pc == nil {
+                return
+        }
+
+        offer, err := pc.CreateOffer(nil)
+        if err != nil {
+                log.Printf("✗ Failed to create renegotiation offer: %v", err)
+                return
+        }
+
+        if err := pc.SetLocalDescription(offer); err != nil {
+                log.Printf("✗ Failed to set renegotiation local description: %v", err)
+                return
+        }
+
+        offerData, err := json.Marshal(offer)
+        if err != nil {
+                log.Printf("✗ Failed to marshal renegotiation offer: %v", err)
+                return
+        }
+
+        h.wsLock.Lock()
+        defer h.wsLock.Unlock()
+        if h.wsConn != nil {
+                if err := h.wsConn.WriteJSON(Message{Type: "renegotiate", Data: offerData, CallID: call.id}); err != nil {
+                        log.Printf("✗ Failed to send renegotiate offer: %v", err)
+                }
+        }
+}
+
+// newCallID generates a random call ID, used to key Helper.calls and
+// tag RTP frames so a single shared libp2p stream can multiplex several
+// calls to the same remote peer.
+func newCallID() (string, error) {
+        var b [16]byte
+        if _, err := rand.Read(b[:]); err != nil {
+                return "", err
+        }
+        return hex.EncodeToString(b[:]), nil
+}
+
+// getCall returns the Call for callID, or nil if there isn't one.
+func (h *Helper) getCall(callID string) *Call {
+        h.callsLock.Lock()
+        defer h.callsLock.Unlock()
+        return h.calls[callID]
+}
+
+// newCall creates and registers a new Call to remotePeerID under a
+// freshly generated call ID.
+func (h *Helper) newCall(remotePeerID string) (*Call, error) {
+        id, err := newCallID()
+        if err != nil {
+                return nil, fmt.Errorf("failed to generate call ID: %w", err)
+        }
+
+        call := &Call{id: id, remotePeerID: remotePeerID}
+
+        h.callsLock.Lock()
+        h.calls[id] = call
+        h.callsLock.Unlock()
+
+        return call, nil
+}
+
+// handleWebSocket handles WebSocket connection from browser
+func (h *Helper) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+        conn, err := h.wsUpgrader.Upgrade(w, r, nil)
+        if err != nil {
+                log.Printf("✗ WebSocket upgrade failed: %v", err)
+                return
+        }
+
+        h.wsLock.Lock()
+        h.wsConn = conn
+        h.wsLock.Unlock()
+
+        log.Println("✓ Browser WebSocket connected")
+
+        // Send local peer ID
+        if err := conn.WriteJSON(Message{
+                Type: "peer-id",
+                Data: json.RawMessage(fmt.Sprintf(`"%s"`, h.host.ID().String())),
+        }); err != nil {
+                log.Printf("✗ Failed to send peer ID: %v", err)
+        }
+
+        defer func() {
+                h.wsLock.Lock()
+                h.wsConn = nil
+                h.wsLock.Unlock()
+                conn.Close()
+        }()
+
+        // Read messages from browser
+        for {
+                var msg Message
+                if err := conn.ReadJSON(&msg); err != nil {
+                        log.Printf("✗ WebSocket read error: %v", err)
+                        return
+                }
+
+                if err := h.handleBrowserMessage(msg); err != nil {
+                        log.Printf("✗ Error handling message: %v", err)
+                }
+        }
+}
+
+// handleBrowserMessage handles messages from browser
+func (h *Helper) handleBrowserMessage(msg Message) error {
+        switch msg.Type {
+        case "connect-peer":
+                // Browser wants to connect to a remote peer; give the
+                // call a fresh ID now so connect-peer, offer, and
+                // ice-candidate for it can all be correlated via CallID.
+                var peerID string
+                if err := json.Unmarshal(msg.Data, &peerID); err != nil {
+                        return err
+                }
+                call, err := h.newCall(peerID)
+                if err != nil {
+                        return err
+                }
+
+                // Tell the browser this call's ID right away so it can
+                // tag the offer/ice-candidate messages it sends for this
+                // call; connectToPeer's own peer-connected notification
+                // arrives later, once the peer is actually reachable.
+                h.wsLock.Lock()
+                if h.wsConn != nil {
+                        h.wsConn.WriteJSON(Message{Type: "call-id", CallID: call.id})
+                }
+                h.wsLock.Unlock()
+
+                go h.connectToPeer(call, peerID)
+
+        case "offer":
+                // Browser sent SDP offer. If it's not answering a call we
+                // already started via connect-peer (e.g. it's waiting for
+                // an inbound call), start one now.
+                call := h.getCall(msg.CallID)
+                if call == nil {
+                        var err error
+                        call, err = h.newCall("")
+                        if err != nil {
+                                return err
+                        }
+                }
+
+                if err := call.createBrowserPeerConnection(h); err != nil {
+                        return err
+                }
+
+                var offer webrtc.SessionDescription
+                if err := json.Unmarshal(msg.Data, &offer); err != nil {
+                        return err
+                }
+
+                if err := call.browserPC.SetRemoteDescription(offer); err != nil {
+                        return err
+                }
+
+                // Create answer
+                answer, err := call.browserPC.CreateAnswer(nil)
+                if err != nil {
+                        return err
+                }
+
+                if err := call.browserPC.SetLocalDescription(answer); err != nil {
+                        return err
+                }
+
+                // Send answer back to browser
+                answerData, _ := json.Marshal(answer)
+                h.wsLock.Lock()
+                if h.wsConn != nil {
+                        h.wsConn.WriteJSON(Message{
+                                Type:   "answer",
+                                Data:   answerData,
+                                CallID: call.id,
+                        })
+                }
+                h.wsLock.Unlock()
+
+        case "ice-candidate":
+                // Browser sent ICE candidate for a call's peer connection
+                call := h.getCall(msg.CallID)
+                if call != nil && call.browserPC != nil {
+                        var candidate webrtc.ICECandidateInit
+                        if err := json.Unmarshal(msg.Data, &candidate); err != nil {
+                                return err
+                        }
+                        if err := call.browserPC.AddICECandidate(candidate); err != nil {
+                                log.Printf("⚠ Failed to add ICE candidate: %v", err)
+                        }
+                }
+
+        case "renegotiate-answer":
+                // Browser answered a renegotiation offer we sent after
+                // adding a new inbound track to one of its calls.
+                call := h.getCall(msg.CallID)
+                if call == nil || call.browserPC == nil {
+                        return fmt.Errorf("no browser peer connection for renegotiation answer")
+                }
+
+                var answer webrtc.SessionDescription
+                if err := json.Unmarshal(msg.Data, &answer); err != nil {
+                        return err
+                }
+
+                if err := call.browserPC.SetRemoteDescription(answer); err != nil {
+                        return err
+                }
+
+        case "set-call-fanout":
+                // Browser toggles SFU-style fan-out for a call: inbound
+                // RTP from that call's remote peer gets retransmitted to
+                // every other active call's remote peer.
+                var req struct {
+                        CallID  string `json:"callId"`
+                        Enabled bool   `json:"enabled"`
+                }
+                if err := json.Unmarshal(msg.Data, &req); err != nil {
+                        return err
+                }
+                call := h.getCall(req.CallID)
+                if call == nil {
+                        return fmt.Errorf("unknown call %s", req.CallID)
+                }
+                call.fanOutLock.Lock()
+                call.fanOut = req.Enabled
+                call.fanOutLock.Unlock()
+                log.Printf("✓ Call %s fan-out set to %v", req.CallID, req.Enabled)
+
+        case "join-room":
+                // Browser wants to discover peers in a named room without
+                // knowing their peer IDs up front.
+                var req struct {
+                        RoomID       string   `json:"roomId"`
+                        Capabilities []string `json:"capabilities"`
+                }
+                if err := json.Unmarshal(msg.Data, &req); err != nil {
+                        return err
+                }
+                go h.joinRoom(req.RoomID, req.Capabilities)
+
+        case "leave-room":
+                var roomID string
+                if err := json.Unmarshal(msg.Data, &roomID); err != nil {
+                        return err
+                }
+                h.leaveRoom(roomID)
+        }
+
+        return nil
+}
+
+// connectToPeer connects to a remote peer by PeerID on behalf of call.
+func (h *Helper) connectToPeer(call *Call, peerIDStr string) {
+        peerID, err := peer.Decode(peerIDStr)
+        if err != nil {
+                log.Printf("✗ Invalid peer ID: %v", err)
+                return
+        }
+
+        log.Printf("→ Connecting to peer %s (call %s)...", peerID.ShortString(), call.id)
+
+        // Find peer in DHT
+        ctx, cancel := context.WithTimeout(h.ctx, 30*time.Second)
+        defer cancel()
+
+        peerInfo, err := h.dht.FindPeer(ctx, peerID)
+        if err != nil {
+                log.Printf("✗ Failed to find peer: %v", err)
+                return
+        }
+
+        // Try a direct connection first; if the peer is unreachable
+        // (e.g. it's behind a symmetric NAT with no public address), fall
+        // back to dialing it through a circuit v2 relay it advertises.
+        if err := h.host.Connect(ctx, peerInfo); err != nil {
+                log.Printf("⚠ Direct connect failed, trying relay: %v", err)
+                if err := h.connectViaRelay(ctx, peerID, peerInfo.Addrs); err != nil {
+                        log.Printf("✗ Failed to connect via relay: %v", err)
+                        return
+                }
+        }
+
+        log.Printf("✓ Connected to %s", peerID.ShortString())
+
+        if addrsAreWebRTCOnly(peerInfo.Addrs) {
+                // The peer has no transport besides /webrtc, so an
+                // RTPProtocol stream would never actually carry data; fall
+                // back to negotiating a direct WebRTC connection over the
+                // signaling stream we do have.
+                log.Printf("→ %s only advertises a /webrtc address, negotiating a private WebRTC link", peerID.ShortString())
+                if err := h.negotiatePrivateWebRTC(ctx, call, peerID); err != nil {
+                        log.Printf("✗ Failed to negotiate private WebRTC link: %v", err)
+                        return
+                }
+        } else {
+                // Open (or reuse) the shared RTP stream to this peer.
+                pc, err := h.getOrCreatePeerConn(ctx, peerID)
+                if err != nil {
+                        log.Printf("✗ Failed to open RTP stream: %v", err)
+                        return
+                }
+
+                call.streamLock.Lock()
+                call.rtpConn = &streamRTPConn{peer: pc, callID: call.id}
+                call.streamLock.Unlock()
+
+                log.Println("✓ RTP stream established")
+        }
+
+        // Notify browser
+        h.wsLock.Lock()
+        if h.wsConn != nil {
+                h.wsConn.WriteJSON(Message{
+                        Type:   "peer-connected",
+                        Data:   json.RawMessage(fmt.Sprintf(`"%s"`, peerIDStr)),
+                        CallID: call.id,
+                })
+        }
+        h.wsLock.Unlock()
+}
+
+// connectViaRelay dials peerID through a relay peerID itself holds a
+// reservation on — holding a reservation on a relay only means that
+// relay will forward connections *to us*, so reaching peerID requires
+// whichever relay *it* is reachable through, learned from peerID's own
+// advertised addresses (peerAddrs, normally just-fetched from the DHT).
+// If those don't already carry a /p2p-circuit address (e.g. the DHT
+// record we saw was stale), we fall back to peerID's relay rendezvous
+// advertisement. Once the relayed connection is up, EnableHolePunching
+// (already set on this host) takes over transparently attempting a
+// direct upgrade via DCUtR, so no further action is needed here.
+func (h *Helper) connectViaRelay(ctx context.Context, peerID peer.ID, peerAddrs []multiaddr.Multiaddr) error {
+        circuitAddrs := circuitAddrsOf(peerAddrs)
+        if len(circuitAddrs) == 0 {
+                discovered, err := h.findPeerRelayAddrs(ctx, peerID)
+                if err != nil {
+                        return fmt.Errorf("%s advertises no circuit address and relay lookup failed: %w", peerID.ShortString(), err)
+                }
+                circuitAddrs = discovered
+        }
+
+        if len(circuitAddrs) == 0 {
+                return fmt.Errorf("%s has no advertised circuit relay address", peerID.ShortString())
+        }
+
+        relayInfo := peer.AddrInfo{ID: peerID, Addrs: circuitAddrs}
+        if err := h.host.Connect(ctx, relayInfo); err != nil {
+                return err
+        }
+
+        log.Printf("✓ Connected to %s via its advertised relay", peerID.ShortString())
+        return nil
+}
+
+// findPeerRelayAddrs confirms peerID has advertised relay reachability
+// under relayRendezvous, then re-fetches its AddrInfo from the DHT in
+// case a fresher record now carries its /p2p-circuit address.
+func (h *Helper) findPeerRelayAddrs(ctx context.Context, peerID peer.ID) ([]multiaddr.Multiaddr, error) {
+        peerChan, err := h.discovery.FindPeers(ctx, relayRendezvous(peerID))
+        if err != nil {
+                return nil, err
+        }
+
+        found := false
+        for p := range peerChan {
+                if p.ID == peerID {
+                        found = true
+                }
+        }
+        if !found {
+                return nil, fmt.Errorf("no relay advertisement from %s", peerID.ShortString())
+        }
+
+        peerInfo, err := h.dht.FindPeer(ctx, peerID)
+        if err != nil {
+                return nil, err
+        }
+        return circuitAddrsOf(peerInfo.Addrs), nil
+}
+
+// circuitAddrsOf filters addrs down to the ones that already carry a
+// /p2p-circuit component, i.e. addresses dialable through a relay the
+// peer itself holds a reservation on.
+func circuitAddrsOf(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+        var out []multiaddr.Multiaddr
+        for _, addr := range addrs {
+                if strings.Contains(addr.String(), "/p2p-circuit") {
+                        out = append(out, addr)
+                }
+        }
+        return out
+}
+
+// relayCircuitAddr builds the /p2p-circuit multiaddr used to dial target
+// through relay, preferring one of the relay's own public addresses when
+// we have one and falling back to the relay's bare peer ID otherwise.
+func relayCircuitAddr(relay peer.ID, relayAddrs []multiaddr.Multiaddr, target peer.ID) (multiaddr.Multiaddr, error) {
+        var relayAddr multiaddr.Multiaddr
+        if len(relayAddrs) > 0 {
+                relayWithID, err := multiaddr.NewMultiaddr(fmt.Sprintf("/p2p/%s", relay))
+                if err != nil {
+                        return nil, err
+                }
+                relayAddr = relayAddrs[0].Encapsulate(relayWithID)
+        } else {
+                addr, err := multiaddr.NewMultiaddr(fmt.Sprintf("/p2p/%s", relay))
+                if err != nil {
+                        return nil, err
+                }
+                relayAddr = addr
+        }
+
+        circuitSuffix, err := multiaddr.NewMultiaddr(fmt.Sprintf("/p2p-circuit/p2p/%s", target))
+        if err != nil {
+                return nil, err
+        }
+
+        return relayAddr.Encapsulate(circuitSuffix), nil
+}
+
+// addrsAreWebRTCOnly reports whether every address in addrs carries a
+// /webrtc component, meaning the peer advertises no other dialable
+// transport and so can only be reached by negotiating a private WebRTC
+// connection over an existing libp2p stream.
+func addrsAreWebRTCOnly(addrs []multiaddr.Multiaddr) bool {
+        if len(addrs) == 0 {
+                return false
+        }
+        for _, addr := range addrs {
+                hasWebRTC := false
+                for _, p := range addr.Protocols() {
+                        if p.Name == "webrtc" {
+                                hasWebRTC = true
+                                break
+                        }
+                }
+                if !hasWebRTC {
+                        return false
+                }
+        }
+        return true
+}
+
+// webrtcSignalMessage is exchanged once in each direction over a
+// WebRTCSignalProtocol stream to carry a complete (non-trickled) SDP
+// offer or answer: we wait for ICE gathering to finish before sending,
+// so a single round trip is enough. CallID tags the offer with the call
+// it belongs to, since the stream carries no other way to correlate it.
+type webrtcSignalMessage struct {
+        Type   string                     `json:"type"`
+        SDP    *webrtc.SessionDescription `json:"sdp,omitempty"`
+        CallID string                     `json:"callId,omitempty"`
+}
+
+// negotiatePrivateWebRTC opens a WebRTCSignalProtocol stream to peerID
+// and negotiates a direct WebRTC connection carrying call's RTP over an
+// "rtp" data channel, for use when peerID has no transport we could open
+// an RTPProtocol stream over. The stream only carries the SDP handshake;
+// once that's done it's closed and the data channel takes over.
+func (h *Helper) negotiatePrivateWebRTC(ctx context.Context, call *Call, peerID peer.ID) error {
+        stream, err := h.host.NewStream(ctx, peerID, protocol.ID(WebRTCSignalProtocol))
+        if err != nil {
+                return fmt.Errorf("failed to open webrtc-signal stream: %w", err)
+        }
+        defer stream.Close()
+
+        pc, err := webrtc.NewPeerConnection(webrtc.Configuration{ICEServers: h.iceServers})
+        if err != nil {
+                return fmt.Errorf("failed to create private webrtc connection: %w", err)
+        }
+
+        dc, err := pc.CreateDataChannel("rtp", nil)
+        if err != nil {
+                pc.Close()
+                return fmt.Errorf("failed to create rtp data channel: %w", err)
+        }
+        h.wireRTPDataChannel(call, dc)
+
+        offer, err := pc.CreateOffer(nil)
+        if err != nil {
+                pc.Close()
+                return fmt.Errorf("failed to create private webrtc offer: %w", err)
+        }
+
+        gatherComplete := webrtc.GatheringCompletePromise(pc)
+        if err := pc.SetLocalDescription(offer); err != nil {
+                pc.Close()
+                return fmt.Errorf("failed to set private webrtc local description: %w", err)
+        }
+        <-gatherComplete
+
+        if err := json.NewEncoder(stream).Encode(webrtcSignalMessage{Type: "offer", SDP: pc.LocalDescription(), CallID: call.id}); err != nil {
+                pc.Close()
+                return fmt.Errorf("failed to send private webrtc offer: %w", err)
+        }
+
+        var answer webrtcSignalMessage
+        if err := json.NewDecoder(stream).Decode(&answer); err != nil {
+                pc.Close()
+                return fmt.Errorf("failed to read private webrtc answer: %w", err)
+        }
+        if answer.SDP == nil {
+                pc.Close()
+                return fmt.Errorf("peer did not return a private webrtc answer")
+        }
+
+        if err := pc.SetRemoteDescription(*answer.SDP); err != nil {
+                pc.Close()
+                return fmt.Errorf("failed to set private webrtc remote description: %w", err)
+        }
+
+        call.privatePCLock.Lock()
+        call.privatePC = pc
+        call.privatePCLock.Unlock()
+
+        log.Printf("✓ Negotiated private WebRTC link to %s", peerID.ShortString())
+        return nil
+}
+
+// handleWebRTCSignalStream is the WebRTCSignalProtocol stream handler:
+// it answers a private WebRTC offer from a remote peer, accepts its
+// "rtp" data channel, and wires that channel up as the active RTP
+// transport for the call the offer named.
+func (h *Helper) handleWebRTCSignalStream(stream network.Stream) {
+        defer stream.Close()
+
+        remotePeer := stream.Conn().RemotePeer()
+        log.Printf("← Incoming private WebRTC signal from %s", remotePeer.ShortString())
+
+        var offer webrtcSignalMessage
+        if err := json.NewDecoder(stream).Decode(&offer); err != nil {
+                log.Printf("✗ Failed to read private webrtc offer: %v", err)
+                return
+        }
+        if offer.SDP == nil {
+                log.Printf("✗ Private webrtc signal from %s did not carry an offer", remotePeer.ShortString())
+                return
+        }
+
+        call := h.getCall(offer.CallID)
+        if call == nil {
+                call = &Call{id: offer.CallID, remotePeerID: remotePeer.String()}
+                h.callsLock.Lock()
+                h.calls[call.id] = call
+                h.callsLock.Unlock()
+
+                h.wsLock.Lock()
+                if h.wsConn != nil {
+                        h.wsConn.WriteJSON(Message{
+                                Type:   "peer-connected",
+                                Data:   json.RawMessage(fmt.Sprintf(`"%s"`, remotePeer.String())),
+                                CallID: call.id,
+                        })
+                }
+                h.wsLock.Unlock()
+        }
+
+        pc, err := webrtc.NewPeerConnection(webrtc.Configuration{ICEServers: h.iceServers})
+        if err != nil {
+                log.Printf("✗ Failed to create private webrtc connection: %v", err)
+                return
+        }
+
+        pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+                if dc.Label() == "rtp" {
+                        h.wireRTPDataChannel(call, dc)
+                }
+        })
+
+        if err := pc.SetRemoteDescription(*offer.SDP); err != nil {
+                log.Printf("✗ Failed to set private webrtc remote description: %v", err)
+                pc.Close()
+                return
+        }
+
+        answer, err := pc.CreateAnswer(nil)
+        if err != nil {
+                log.Printf("✗ Failed to create private webrtc answer: %v", err)
+                pc.Close()
+                return
+        }
+
+        gatherComplete := webrtc.GatheringCompletePromise(pc)
+        if err := pc.SetLocalDescription(answer); err != nil {
+                log.Printf("✗ Failed to set private webrtc local description: %v", err)
+                pc.Close()
+                return
+        }
+        <-gatherComplete
+
+        if err := json.NewEncoder(stream).Encode(webrtcSignalMessage{Type: "answer", SDP: pc.LocalDescription()}); err != nil {
+                log.Printf("✗ Failed to send private webrtc answer: %v", err)
+                pc.Close()
+                return
+        }
+
+        call.privatePCLock.Lock()
+        call.privatePC = pc
+        call.privatePCLock.Unlock()
+
+        log.Printf("✓ Accepted private WebRTC link from %s (call %s)", remotePeer.ShortString(), call.id)
+}
+
+// wireRTPDataChannel attaches a just-negotiated "rtp" data channel as
+// call's active RTP transport: outbound RTP from the browser now flows
+// directly over the channel, and inbound messages are injected into
+// the call's browser peer connection exactly like frames read off a
+// libp2p RTPProtocol stream.
+func (h *Helper) wireRTPDataChannel(call *Call, dc *webrtc.DataChannel) {
+        dc.OnOpen(func() {
+                call.streamLock.Lock()
+                call.rtpConn = &dataChannelRTPConn{dc: dc}
+                call.streamLock.Unlock()
+                log.Printf("✓ Private WebRTC RTP channel open (call %s)", call.id)
+        })
+
+        dc.OnClose(func() {
+                call.streamLock.Lock()
+                if _, ok := call.rtpConn.(*dataChannelRTPConn); ok {
+                        call.rtpConn = nil
+                }
+                call.streamLock.Unlock()
+        })
+
+        dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+                call.injectRemoteRTP(h, msg.Data)
+        })
+}
+
+// roomRendezvous derives the DHT rendezvous string and gossipsub topic
+// name for a browser-supplied room name. Hashing keeps arbitrary
+// user-chosen room names from leaking structure into the DHT key.
+func roomRendezvous(roomID string) string {
+        sum := sha256.Sum256([]byte(roomID))
+        return roomRendezvousPrefix + hex.EncodeToString(sum[:])
+}
+
+// joinRoom advertises our presence under the room's rendezvous string,
+// subscribes to its gossipsub topic, and starts background loops that
+// discover peers via the DHT and surface the room roster to the
+// browser as peer-discovered events.
+func (h *Helper) joinRoom(roomID string, capabilities []string) {
+        if roomID == "" {
+                log.Println("✗ join-room requires a roomId")
+                return
+        }
+
+        h.roomsLock.Lock()
+        if _, exists := h.rooms[roomID]; exists {
+                h.roomsLock.Unlock()
+                log.Printf("⚠ Already in room %s", roomID)
+                return
+        }
+        h.roomsLock.Unlock()
+
+        rendezvous := roomRendezvous(roomID)
+
+        topic, err := h.pubsub.Join(rendezvous)
+        if err != nil {
+                log.Printf("✗ Failed to join pubsub topic for room %s: %v", roomID, err)
+                return
+        }
+
+        sub, err := topic.Subscribe()
+        if err != nil {
+                topic.Close()
+                log.Printf("✗ Failed to subscribe to room %s: %v", roomID, err)
+                return
+        }
+
+        roomCtx, cancel := context.WithCancel(h.ctx)
+        room := &Room{
+                id:         roomID,
+                rendezvous: rendezvous,
+                topic:      topic,
+                sub:        sub,
+                cancel:     cancel,
+                roster:     make(map[peer.ID]*roomPeer),
+        }
+
+        h.roomsLock.Lock()
+        h.rooms[roomID] = room
+        h.roomsLock.Unlock()
+
+        dutil.Advertise(roomCtx, h.discovery, rendezvous)
+        log.Printf("✓ Advertising in room %s (rendezvous %s)", roomID, rendezvous)
+
+        go h.publishPresence(roomCtx, room, capabilities)
+        go h.discoverRoomPeers(roomCtx, room)
+        go h.readRoomPresence(roomCtx, room)
+}
+
+// leaveRoom tears down a previously joined room: it stops the discovery
+// and presence loops, closes the pubsub subscription and topic, and
+// drops the room from the Helper's room set.
+func (h *Helper) leaveRoom(roomID string) {
+        h.roomsLock.Lock()
+        room, ok := h.rooms[roomID]
+        if ok {
+                delete(h.rooms, roomID)
+        }
+        h.roomsLock.Unlock()
+
+        if !ok {
+                return
+        }
+
+        room.cancel()
+        room.sub.Cancel()
+        room.topic.Close()
+        log.Printf("✓ Left room %s", roomID)
+}
+
+// publishPresence periodically re-publishes our own capabilities to the
+// room's gossipsub topic so peers who join after us still learn what we
+// can send, since gossipsub doesn't replay messages to late subscribers.
+func (h *Helper) publishPresence(ctx context.Context, room *Room, capabilities []string) {
+        presence, err := json.Marshal(roomPresence{Capabilities: capabilities})
+        if err != nil {
+                log.Printf("✗ Failed to marshal room presence: %v", err)
+                return
+        }
+
+        publishOnce := func() {
+                if err := room.topic.Publish(ctx, presence); err != nil {
+                        log.Printf("⚠ Failed to publish presence to room %s: %v", room.id, err)
+                }
+        }
+
+        publishOnce()
+
+        ticker := time.NewTicker(roomDiscoveryInterval)
+        defer ticker.Stop()
+
+        for {
+                select {
+                case <-ctx.Done():
+                        return
+                case <-ticker.C:
+                        publishOnce()
+                }
+        }
+}
+
+// discoverRoomPeers periodically queries the DHT for peers advertising
+// the room and hands each peer off to the room roster via noteRoomPeer,
+// which surfaces genuinely new peers to the browser.
+func (h *Helper) discoverRoomPeers(ctx context.Context, room *Room) {
+        ticker := time.NewTicker(roomDiscoveryInterval)
+        defer ticker.Stop()
+
+        findOnce := func() {
+                peerChan, err := h.discovery.FindPeers(ctx, room.rendezvous)
+                if err != nil {
+                        log.Printf("✗ FindPeers failed for room %s: %v", room.id, err)
+                        return
+                }
+
+                for p := range peerChan {
+                        if p.ID == h.host.ID() || len(p.Addrs) == 0 {
+                                continue
+                        }
+                        h.noteRoomPeer(room, p.ID, nil)
+                }
+        }
+
+        findOnce()
+        for {
+                select {
+                case <-ctx.Done():
+                        return
+                case <-ticker.C:
+                        findOnce()
+                }
+        }
+}
+
+// readRoomPresence relays capability announcements published by other
+// room members into the roster, via noteRoomPeer.
+func (h *Helper) readRoomPresence(ctx context.Context, room *Room) {
+        for {
+                psMsg, err := room.sub.Next(ctx)
+                if err != nil {
+                        return
+                }
+
+                if psMsg.ReceivedFrom == h.host.ID() {
+                        continue
+                }
+
+                var presence roomPresence
+                if err := json.Unmarshal(psMsg.Data, &presence); err != nil {
+                        log.Printf("⚠ Ignoring malformed presence in room %s: %v", room.id, err)
+                        continue
+                }
+
+                h.noteRoomPeer(room, psMsg.ReceivedFrom, presence.Capabilities)
+        }
+}
+
+// noteRoomPeer records a peer's capabilities in the room roster and, the
+// first time we see it (or the first time we learn its capabilities),
+// notifies the browser with a peer-discovered message so the UI can
+// build a de-duplicated room roster.
+func (h *Helper) noteRoomPeer(room *Room, id peer.ID, capabilities []string) {
+        room.rosterLock.Lock()
+        existing, known := room.roster[id]
+        if known && (capabilities == nil || len(existing.capabilities) > 0) {
+                room.rosterLock.Unlock()
+                return
+        }
+        room.roster[id] = &roomPeer{id: id, capabilities: capabilities}
+        room.rosterLock.Unlock()
+
+        log.Printf("✓ Discovered peer %s in room %s", id.ShortString(), room.id)
+
+        data, err := json.Marshal(struct {
+                PeerID       string   `json:"peerId"`
+                RoomID       string   `json:"roomId"`
+                Capabilities []string `json:"capabilities"`
+        }{
+                PeerID:       id.String(),
+                RoomID:       room.id,
+                Capabilities: capabilities,
+        })
+        if err != nil {
+                log.Printf("✗ Failed to marshal peer-discovered message: %v", err)
+                return
+        }
+
+        h.wsLock.Lock()
+        defer h.wsLock.Unlock()
+        if h.wsConn != nil {
+                if err := h.wsConn.WriteJSON(Message{Type: "peer-discovered", Data: data}); err != nil {
+                        log.Printf("✗ Failed to send peer-discovered: %v", err)
+                }
+        }
+}
+
+// Start starts the helper
+func (h *Helper) Start() error {
+        fmt.Println("\n╔══════════════════════════════════════════════════════════════╗")
+        fmt.Println("║          SECURE.LINK P2P Helper (Refactored)                ║")
+        fmt.Println("╚══════════════════════════════════════════════════════════════╝")
+        fmt.Printf("\n🆔 Your Peer ID: %s\n", h.host.ID().String())
+        fmt.Printf("🌐 WebSocket Server: ws://127.0.0.1:%d\n", WSPort)
+        fmt.Println("\n📡 Listening addresses:")
+        for _, addr := range h.host.Addrs() {
+                fmt.Printf("   - %s/p2p/%s\n", addr, h.host.ID().ShortString())
+        }
+        fmt.Println("\n✓ Using go-libp2p-webrtc-direct transport")
+        fmt.Println("✓ Using Pion RTP forwarder pattern")
+        fmt.Println("\n━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━\n")
+
+        // Start WebSocket server
+        http.HandleFunc("/", h.handleWebSocket)
+        server := &http.Server{
+                Addr:    fmt.Sprintf("127.0.0.1:%d", WSPort),
+                Handler: http.DefaultServeMux,
+        }
+
+        go func() {
+                if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+                        log.Fatalf("✗ WebSocket server error: %v", err)
+                }
+        }()
+
+        // Wait for shutdown signal
+        sigChan := make(chan os.Signal, 1)
+        signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+        <-sigChan
+
+        fmt.Println("\n⏳ Shutting down...")
+        server.Shutdown(context.Background())
+        h.cancel()
+
+        h.callsLock.Lock()
+        for _, call := range h.calls {
+                call.pcLock.Lock()
+                if call.browserPC != nil {
+                        call.browserPC.Close()
+                }
+                call.pcLock.Unlock()
+
+                call.privatePCLock.Lock()
+                if call.privatePC != nil {
+                        call.privatePC.Close()
+                }
+                call.privatePCLock.Unlock()
+        }
+        h.callsLock.Unlock()
+
+        h.peerConnsLock.Lock()
+        for _, pc := range h.peerConns {
+                pc.Close()
+        }
+        h.peerConnsLock.Unlock()
+
+        h.host.Close()
+        fmt.Println("✓ Goodbye!")
+        return nil
+}
+
+func main() {
+        relayServer := flag.Bool("relay-server", false, "advertise this node as a circuit v2 relay for other peers")
+        relayNodesFlag := flag.String("relay-nodes", "", "comma-separated multiaddrs of circuit v2 relay candidates (default: bootstrap peers)")
+        iceServersFlag := flag.String("ice-servers", "", "path to a JSON file of STUN/TURN servers (default: a public STUN server)")
+        identitySpec := flag.String("identity", "default", `identity storage to use: "default" (OS config dir), "encrypted" (passphrase-protected), or "agent:<unix socket path>"`)
+        flag.Parse()
+
+        var relayNodes []peer.AddrInfo
+        if *relayNodesFlag != "" {
+                relayNodes = parseAddrInfos(strings.Split(*relayNodesFlag, ","))
+        }
+
+        var iceServers []webrtc.ICEServer
+        if *iceServersFlag != "" {
+                loaded, err := loadICEServers(*iceServersFlag)
+                if err != nil {
+                        log.Fatalf("✗ Failed to load ICE servers from %s: %v", *iceServersFlag, err)
+                }
+                iceServers = loaded
+        }
+
+        identityStore, err := newIdentityStore(*identitySpec)
+        if err != nil {
+                log.Fatalf("✗ Invalid --identity: %v", err)
+        }
+
+        ctx := context.Background()
+        helper, err := NewHelper(ctx, *relayServer, relayNodes, iceServers, identityStore)
+        if err != nil {
+                log.Fatalf("✗ Failed to create helper: %v", err)
+        }
+
+        if err := helper.Start(); err != nil {
+                log.Fatalf("✗ Helper error: %v", err)
+        }
+}
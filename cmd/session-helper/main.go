@@ -0,0 +1,1709 @@
+package main
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+	"github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	circuitv2client "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	"github.com/libp2p/go-libp2p/p2p/security/noise"
+	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+const (
+	// SignalingProtocolV1 is the original, unframed signaling protocol.
+	// We keep speaking it so older helpers can still dial us; libp2p's
+	// multistream negotiation picks it automatically when a peer
+	// doesn't support SignalingProtocolV2.
+	SignalingProtocolV1 = "/securelink/signaling/1.0.0"
+
+	// SignalingProtocolV2 frames each message with a 4-byte big-endian
+	// length prefix (capped at maxFrameSize) so a malformed or oversized
+	// payload from a remote peer can't hang the decoder or exhaust
+	// memory the way naked json.Decoder streaming could.
+	SignalingProtocolV2 = "/securelink/signaling/2.0.0"
+
+	// maxFrameSize caps a single signaling frame's payload.
+	maxFrameSize = 1 << 20 // 1 MiB
+
+	// peerIOTimeout bounds how long a single write, or reading a message
+	// already known to be in flight (i.e. after its length header has
+	// arrived), may take before we give up on that peer.
+	peerIOTimeout = 15 * time.Second
+
+	// peerIdleTimeout bounds how long we'll wait for the *next* message
+	// on an otherwise-healthy peer signaling stream. A real stream
+	// routinely goes quiet for longer than peerIOTimeout once ICE
+	// trickling finishes or during a long call with no further
+	// signaling, so this is generous; it only needs to catch a peer
+	// that's gone away for good.
+	peerIdleTimeout = 5 * time.Minute
+
+	// peerOutboxCapacity is the size of each peer's bounded outbound
+	// queue; forwardToPeer drops the peer rather than blocking when it's full.
+	peerOutboxCapacity = 32
+
+	// WebSocket server port
+	WSPort = 52100
+
+	// rendezvousPrefix namespaces our rooms within the shared DHT so we
+	// don't collide with other libp2p applications advertising the same
+	// human-readable room names.
+	rendezvousPrefix = "/securelink/room/"
+
+	// mdnsServiceName namespaces our mDNS announcements so we only
+	// discover other securelink helpers on the local network, not every
+	// libp2p node advertising the default service name.
+	mdnsServiceName = "_securelink-p2p._udp"
+
+	// mdnsHostnameLookupTimeout bounds the reverse DNS lookup friendlyHostname
+	// does against a newly discovered peer's addresses, so a slow or
+	// unreachable resolver can't stall mDNS peer-found handling.
+	mdnsHostnameLookupTimeout = 3 * time.Second
+
+	// roomDiscoveryInterval is how often we re-query the DHT for peers
+	// advertising a room we've joined.
+	roomDiscoveryInterval = 20 * time.Second
+
+	// relayRendezvous is the rendezvous string relay-capable helpers
+	// advertise themselves under, so NAT-blocked peers can find them
+	// via the DHT instead of requiring a hardcoded relay list.
+	relayRendezvous = "/libp2p/relay"
+
+	// desiredRelayCount is how many distinct relays we try to hold
+	// reservations on at once, so a single relay going away doesn't
+	// leave us completely unreachable.
+	desiredRelayCount = 3
+
+	// relayRefreshMargin is how long before a reservation expires that
+	// we attempt to renew it.
+	relayRefreshMargin = 2 * time.Minute
+
+	// identityConfigDirName is the application subdirectory created under
+	// os.UserConfigDir() to hold on-disk identity stores.
+	identityConfigDirName = "securelink"
+
+	// scrypt parameters for the passphrase-encrypted identity store.
+	// These match the scrypt-recommended interactive work factor as of
+	// this writing; bump scryptN if hardware moves on.
+	scryptN        = 1 << 15
+	scryptR        = 8
+	scryptP        = 1
+	scryptKeyLen   = chacha20poly1305.KeySize
+	scryptSaltSize = 16
+)
+
+// Message types
+type Message struct {
+	Type      string          `json:"type"`
+	From      string          `json:"from,omitempty"`
+	To        string          `json:"to,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	PeerID    string          `json:"peerId,omitempty"`
+	RoomID    string          `json:"roomId,omitempty"`
+	MsgID     string          `json:"msgId,omitempty"`
+	InReplyTo string          `json:"inReplyTo,omitempty"`
+}
+
+// Room tracks the state needed to keep a single rendezvous room alive:
+// the pubsub topic browsers broadcast small JSON payloads over, the
+// peers we've already surfaced so we don't spam duplicate
+// peer-discovered events, and the sessions currently subscribed to it
+// so events only fan out to browser tabs that actually joined.
+type Room struct {
+	id              string
+	rendezvous      string
+	topic           *pubsub.Topic
+	sub             *pubsub.Subscription
+	cancel          context.CancelFunc
+	seenLock        sync.Mutex
+	seenPeers       map[peer.ID]bool
+	subscribersLock sync.Mutex
+	subscribers     map[string]bool // sessionID -> joined
+}
+
+// peerConn wraps a signaling stream to one remote peer with the
+// negotiated protocol version and a bounded outbound queue. A dedicated
+// writer goroutine drains the queue so a slow or wedged peer applies
+// backpressure to its own queue instead of blocking whichever goroutine
+// is trying to forward a message to it.
+type peerConn struct {
+	stream    network.Stream
+	protoVer  protocol.ID
+	outbox    chan Message
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Session represents one connected browser tab. Each tab gets its own
+// WebSocket, its own set of open libp2p signaling streams, and its own
+// set of joined rooms, so a second tab no longer clobbers the first.
+type Session struct {
+	id     string
+	wsConn *websocket.Conn
+	wsLock sync.Mutex
+
+	peerStreams     map[string]*peerConn // peerID -> signaling connection owned by this session
+	peerStreamsLock sync.RWMutex
+
+	roomSubscriptions     map[string]bool // roomID -> joined, for cleanup on disconnect
+	roomSubscriptionsLock sync.Mutex
+}
+
+// Helper represents the P2P helper application
+type Helper struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	host       host.Host
+	dht        *dht.IpfsDHT
+	wsUpgrader websocket.Upgrader
+
+	// sessions is keyed by the browser-supplied (or generated) session
+	// ID, allowing multiple browser tabs to share this libp2p host
+	// without stepping on each other.
+	sessions     map[string]*Session
+	sessionsLock sync.RWMutex
+
+	// peerOwner tracks which session opened the signaling stream to a
+	// given remote peer, so inbound libp2p messages from that peer are
+	// routed back to the right browser tab.
+	peerOwner     map[string]string // peerID -> sessionID
+	peerOwnerLock sync.RWMutex
+
+	// discovery holds the rendezvous discovery layer used to advertise
+	// and find peers; pubsub carries the per-room broadcast topics.
+	discovery *routing.RoutingDiscovery
+	pubsub    *pubsub.PubSub
+	rooms     map[string]*Room
+	roomsLock sync.Mutex
+
+	// relays holds our active circuit v2 reservations, keyed by the
+	// relay's PeerID, so NAT-blocked peers can still be dialed via
+	// /p2p-circuit once direct and hole-punched connections fail.
+	relays     map[peer.ID]*circuitv2client.Reservation
+	relaysLock sync.Mutex
+
+	// relayAddrs holds the /p2p-circuit addresses derived from our
+	// current relay reservations. addrsFactory (installed on the host in
+	// NewHelper) folds these into host.Addrs(), so both the startup
+	// listing shown to the operator and any DHT lookup of our own peer
+	// record include a dialable relay address for as long as we hold
+	// one. ensureRelays keeps it current as reservations come and go.
+	relayAddrs *atomic.Value // []multiaddr.Multiaddr
+
+	// relayServer, when set, also runs this helper as a circuit v2
+	// relay so other users' helpers can reserve slots on it.
+	relayServer bool
+
+	// localPeers caches peers discovered on the local network via mDNS,
+	// keyed by PeerID, so connectToPeer can dial them directly instead
+	// of paying for a DHT round-trip.
+	localPeers     map[peer.ID]peer.AddrInfo
+	localPeersLock sync.RWMutex
+}
+
+// NewHelper creates a new P2P helper instance. When relayServer is set,
+// the helper also runs a circuit v2 relay service so other helpers can
+// reserve slots on it. identityStore determines where the peer's
+// private key lives and how it's protected at rest.
+func NewHelper(ctx context.Context, relayServer bool, identityStore IdentityStore) (*Helper, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	// Load or generate persistent peer identity
+	privKey, err := loadOrCreateIdentity(identityStore)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to load identity: %w", err)
+	}
+
+	// Create libp2p host with Noise security
+	connManager, err := connmgr.NewConnManager(100, 400, connmgr.WithGracePeriod(time.Minute))
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to create connection manager: %w", err)
+	}
+
+	// relayAddrs is populated by ensureRelays once reservations come in;
+	// addrsFactory folds its current contents into every address we
+	// advertise.
+	relayAddrs := new(atomic.Value)
+	relayAddrs.Store([]multiaddr.Multiaddr{})
+	addrsFactory := func(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+		extra, _ := relayAddrs.Load().([]multiaddr.Multiaddr)
+		return append(addrs, extra...)
+	}
+
+	hostOpts := []libp2p.Option{
+		libp2p.Identity(privKey),
+		libp2p.ListenAddrStrings(
+			"/ip4/0.0.0.0/tcp/0",
+			"/ip6/::/tcp/0",
+			"/ip4/0.0.0.0/udp/0/quic-v1",
+			"/ip6/::/udp/0/quic-v1",
+		),
+		libp2p.Security(libp2ptls.ID, libp2ptls.New),
+		libp2p.Security(noise.ID, noise.New),
+		libp2p.ConnectionManager(connManager),
+		libp2p.NATPortMap(),
+		libp2p.EnableNATService(),
+		libp2p.EnableHolePunching(),
+		libp2p.EnableRelay(),
+		libp2p.AddrsFactory(addrsFactory),
+	}
+	if relayServer {
+		// Power users can self-host a relay for their contacts instead
+		// of relying solely on relays discovered via the DHT.
+		hostOpts = append(hostOpts, libp2p.EnableRelayService())
+	}
+
+	h, err := libp2p.New(hostOpts...)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to create libp2p host: %w", err)
+	}
+
+	// Create Kademlia DHT
+	kadDHT, err := dht.New(ctx, h, dht.Mode(dht.ModeAutoServer))
+	if err != nil {
+		h.Close()
+		cancel()
+		return nil, fmt.Errorf("failed to create DHT: %w", err)
+	}
+
+	// Bootstrap the DHT
+	if err := kadDHT.Bootstrap(ctx); err != nil {
+		h.Close()
+		cancel()
+		return nil, fmt.Errorf("failed to bootstrap DHT: %w", err)
+	}
+
+	// Connect to bootstrap nodes
+	go bootstrapConnect(ctx, h, kadDHT)
+
+	// Rendezvous discovery rides on top of the DHT; gossipsub gives
+	// rooms a lightweight broadcast channel without per-pair streams.
+	routingDiscovery := routing.NewRoutingDiscovery(kadDHT)
+	gossipSub, err := pubsub.NewGossipSub(ctx, h)
+	if err != nil {
+		h.Close()
+		cancel()
+		return nil, fmt.Errorf("failed to create pubsub: %w", err)
+	}
+
+	helper := &Helper{
+		ctx:    ctx,
+		cancel: cancel,
+		host:   h,
+		dht:    kadDHT,
+		wsUpgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				return true // Allow all origins from localhost
+			},
+		},
+		sessions:    make(map[string]*Session),
+		peerOwner:   make(map[string]string),
+		discovery:   routingDiscovery,
+		pubsub:      gossipSub,
+		rooms:       make(map[string]*Room),
+		relays:      make(map[peer.ID]*circuitv2client.Reservation),
+		relayAddrs:  relayAddrs,
+		relayServer: relayServer,
+		localPeers:  make(map[peer.ID]peer.AddrInfo),
+	}
+
+	// Set stream handlers for incoming signaling on both protocol
+	// versions; handleIncomingStream checks stream.Protocol() to decide
+	// whether to frame its writes.
+	h.SetStreamHandler(protocol.ID(SignalingProtocolV2), helper.handleIncomingStream)
+	h.SetStreamHandler(protocol.ID(SignalingProtocolV1), helper.handleIncomingStream)
+
+	if relayServer {
+		dutil.Advertise(ctx, routingDiscovery, relayRendezvous)
+		log.Println("✓ Running as a circuit v2 relay server")
+	}
+	go helper.maintainRelays(ctx)
+
+	// mDNS finds peers on the local link without touching the DHT at
+	// all, so LAN-only setups (offices, LAN parties, air-gapped
+	// networks) work even with no internet access.
+	mdnsService := mdns.NewMdnsService(h, mdnsServiceName, &mdnsNotifee{helper: helper})
+	if err := mdnsService.Start(); err != nil {
+		log.Printf("⚠ Failed to start mDNS discovery: %v", err)
+	}
+
+	return helper, nil
+}
+
+// mdnsNotifee adapts Helper to mdns.Notifee.
+type mdnsNotifee struct {
+	helper *Helper
+}
+
+func (n *mdnsNotifee) HandlePeerFound(pi peer.AddrInfo) {
+	n.helper.handleLocalPeerFound(pi)
+}
+
+// handleLocalPeerFound records a peer discovered on the local network
+// and notifies any connected browser tabs, so the UI can offer a direct
+// LAN connection without the user needing to know the remote PeerID.
+// It's called directly off the mDNS notifee callback, so it must return
+// quickly: a still-present peer re-announces itself repeatedly, and the
+// friendly-hostname lookup in broadcastLocalPeerDiscovered can block on
+// a slow resolver, so both the "already known" check and the broadcast
+// itself must not hold up processing of other peers' announcements.
+func (h *Helper) handleLocalPeerFound(pi peer.AddrInfo) {
+	if pi.ID == h.host.ID() || len(pi.Addrs) == 0 {
+		return
+	}
+
+	h.localPeersLock.Lock()
+	_, alreadyKnown := h.localPeers[pi.ID]
+	h.localPeers[pi.ID] = pi
+	h.localPeersLock.Unlock()
+
+	if alreadyKnown {
+		return
+	}
+
+	h.host.Peerstore().AddAddrs(pi.ID, pi.Addrs, peerstore.TempAddrTTL)
+
+	log.Printf("✓ Discovered local peer %s via mDNS", pi.ID.ShortString())
+
+	go h.broadcastLocalPeerDiscovered(pi)
+}
+
+// broadcastLocalPeerDiscovered tells every connected browser tab about a
+// peer found via mDNS, carrying its advertised multiaddrs and a
+// best-effort friendly hostname.
+func (h *Helper) broadcastLocalPeerDiscovered(pi peer.AddrInfo) {
+	addrs := make([]string, 0, len(pi.Addrs))
+	for _, addr := range pi.Addrs {
+		addrs = append(addrs, addr.String())
+	}
+
+	payload := struct {
+		PeerID   string   `json:"peerId"`
+		Addrs    []string `json:"addrs"`
+		Hostname string   `json:"hostname,omitempty"`
+	}{
+		PeerID:   pi.ID.String(),
+		Addrs:    addrs,
+		Hostname: friendlyHostname(pi),
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("✗ Failed to marshal local-peer-discovered payload: %v", err)
+		return
+	}
+
+	h.sessionsLock.RLock()
+	defer h.sessionsLock.RUnlock()
+	for _, sess := range h.sessions {
+		sess.writeJSON(Message{
+			Type:   "local-peer-discovered",
+			PeerID: pi.ID.String(),
+			Data:   data,
+		})
+	}
+}
+
+// friendlyHostname attempts a reverse DNS lookup against the peer's
+// advertised addresses so the browser can show something more readable
+// than a raw PeerID. It falls back to the PeerID's short form when no
+// reverse record is available, which is the common case on a LAN with
+// no local DNS server.
+func friendlyHostname(pi peer.AddrInfo) string {
+	ctx, cancel := context.WithTimeout(context.Background(), mdnsHostnameLookupTimeout)
+	defer cancel()
+
+	for _, addr := range pi.Addrs {
+		ip, err := manet.ToIP(addr)
+		if err != nil {
+			continue
+		}
+		names, err := net.DefaultResolver.LookupAddr(ctx, ip.String())
+		if err != nil || len(names) == 0 {
+			continue
+		}
+		return strings.TrimSuffix(names[0], ".")
+	}
+	return pi.ID.ShortString()
+}
+
+// IdentityStore loads and persists the Ed25519 private key that backs
+// our PeerID. Implementations differ in where the key material lives
+// and how it's protected at rest, but loadOrCreateIdentity treats them
+// uniformly: Load returning an os.IsNotExist-compatible error means
+// "no identity yet, generate one", and any other error is fatal rather
+// than a cue to silently mint a new PeerID (which would orphan every
+// contact that already has the old one saved).
+type IdentityStore interface {
+	Load() (crypto.PrivKey, error)
+	Save(crypto.PrivKey) error
+}
+
+// loadOrCreateIdentity loads the persistent peer identity from store,
+// generating and saving a new one only if store reports that none
+// exists yet.
+func loadOrCreateIdentity(store IdentityStore) (crypto.PrivKey, error) {
+	privKey, err := store.Load()
+	switch {
+	case err == nil:
+		log.Println("✓ Loaded existing peer identity")
+		return privKey, nil
+	case os.IsNotExist(err):
+		// Fall through and generate a fresh identity.
+	default:
+		return nil, fmt.Errorf("failed to load peer identity: %w", err)
+	}
+
+	privKey, _, err = crypto.GenerateKeyPairWithReader(crypto.Ed25519, 2048, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := store.Save(privKey); err != nil {
+		return nil, fmt.Errorf("failed to save new peer identity: %w", err)
+	}
+
+	log.Println("✓ Generated and saved new peer identity")
+	return privKey, nil
+}
+
+// newIdentityStore resolves the --identity flag value into a concrete
+// IdentityStore: "default" (or "") for a plaintext key under the OS
+// config dir, "encrypted" for a passphrase-protected keyfile in the
+// same place, or "agent:<unix socket path>" to read the key from an
+// external agent (e.g. one backed by a hardware security key) without
+// it ever touching disk here.
+func newIdentityStore(spec string) (IdentityStore, error) {
+	switch {
+	case spec == "" || spec == "default":
+		return newConfigDirIdentityStore()
+	case spec == "encrypted":
+		passphrase, err := promptPassphrase("Enter passphrase for encrypted identity: ")
+		if err != nil {
+			return nil, err
+		}
+		return newEncryptedIdentityStore(passphrase)
+	case strings.HasPrefix(spec, "agent:"):
+		return &agentIdentityStore{socketPath: strings.TrimPrefix(spec, "agent:")}, nil
+	default:
+		return nil, fmt.Errorf("unknown --identity value %q (want \"default\", \"encrypted\", or \"agent:<socket path>\")", spec)
+	}
+}
+
+// promptPassphrase prompts on stderr and reads a passphrase from the
+// terminal without echoing it.
+func promptPassphrase(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("passphrase must not be empty")
+	}
+	return passphrase, nil
+}
+
+// identityConfigPath returns path/name under the OS-appropriate config
+// directory, creating the application subdirectory if needed.
+func identityConfigPath(name string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve config dir: %w", err)
+	}
+
+	appDir := filepath.Join(dir, identityConfigDirName)
+	if err := os.MkdirAll(appDir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create config dir: %w", err)
+	}
+
+	return filepath.Join(appDir, name), nil
+}
+
+// atomicWriteFile writes data to a temp file alongside path and renames
+// it into place, so a crash mid-write can't leave a corrupted identity
+// file where a good one used to be.
+func atomicWriteFile(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// configDirIdentityStore keeps an unencrypted marshaled private key
+// under the OS-appropriate config directory. It's the default store:
+// a step up from a key file dropped in the CWD, but still meant for a
+// single-user machine where the config dir itself is the trust boundary.
+type configDirIdentityStore struct {
+	path string
+}
+
+func newConfigDirIdentityStore() (*configDirIdentityStore, error) {
+	path, err := identityConfigPath("identity.key")
+	if err != nil {
+		return nil, err
+	}
+	return &configDirIdentityStore{path: path}, nil
+}
+
+func (s *configDirIdentityStore) Load() (crypto.PrivKey, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	privKey, err := crypto.UnmarshalPrivateKey(data)
+	if err != nil {
+		backupPath := s.path + ".bak"
+		if backupErr := os.Rename(s.path, backupPath); backupErr != nil {
+			log.Printf("⚠ Failed to back up unreadable identity file: %v", backupErr)
+		} else {
+			log.Printf("⚠ Backed up unreadable identity file to %s", backupPath)
+		}
+		return nil, fmt.Errorf("failed to unmarshal identity key: %w", err)
+	}
+
+	return privKey, nil
+}
+
+func (s *configDirIdentityStore) Save(privKey crypto.PrivKey) error {
+	data, err := crypto.MarshalPrivateKey(privKey)
+	if err != nil {
+		return err
+	}
+	return atomicWriteFile(s.path, data, 0600)
+}
+
+// encryptedIdentityStore protects the marshaled private key at rest
+// with a passphrase, via scrypt key derivation and XChaCha20-Poly1305.
+// On-disk layout is salt || nonce || ciphertext.
+type encryptedIdentityStore struct {
+	path       string
+	passphrase []byte
+}
+
+func newEncryptedIdentityStore(passphrase []byte) (*encryptedIdentityStore, error) {
+	path, err := identityConfigPath("identity.key.enc")
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedIdentityStore{path: path, passphrase: passphrase}, nil
+}
+
+func (s *encryptedIdentityStore) Load() (crypto.PrivKey, error) {
+	blob, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(blob) < scryptSaltSize+chacha20poly1305.NonceSizeX {
+		return nil, fmt.Errorf("encrypted identity file is truncated")
+	}
+	salt := blob[:scryptSaltSize]
+	nonce := blob[scryptSaltSize : scryptSaltSize+chacha20poly1305.NonceSizeX]
+	ciphertext := blob[scryptSaltSize+chacha20poly1305.NonceSizeX:]
+
+	aead, err := s.cipher(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt identity (wrong passphrase?): %w", err)
+	}
+
+	return crypto.UnmarshalPrivateKey(plaintext)
+}
+
+func (s *encryptedIdentityStore) Save(privKey crypto.PrivKey) error {
+	data, err := crypto.MarshalPrivateKey(privKey)
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, scryptSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	aead, err := s.cipher(salt)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	ciphertext := aead.Seal(nil, nonce, data, nil)
+
+	blob := make([]byte, 0, len(salt)+len(nonce)+len(ciphertext))
+	blob = append(blob, salt...)
+	blob = append(blob, nonce...)
+	blob = append(blob, ciphertext...)
+
+	return atomicWriteFile(s.path, blob, 0600)
+}
+
+func (s *encryptedIdentityStore) cipher(salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(s.passphrase, salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	return chacha20poly1305.NewX(key)
+}
+
+// agentIdentityStore reads the private key from an external agent over
+// a Unix socket, so hardware-key-backed setups never need to write key
+// material to this machine's disk at all. It's read-only: the agent
+// owns key generation and rotation.
+type agentIdentityStore struct {
+	socketPath string
+}
+
+func (s *agentIdentityStore) Load() (crypto.PrivKey, error) {
+	conn, err := net.Dial("unix", s.socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach identity agent at %s: %w", s.socketPath, err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("GET_IDENTITY\n")); err != nil {
+		return nil, fmt.Errorf("failed to request identity from agent: %w", err)
+	}
+
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity from agent: %w", err)
+	}
+
+	return crypto.UnmarshalPrivateKey(data)
+}
+
+func (s *agentIdentityStore) Save(crypto.PrivKey) error {
+	return fmt.Errorf("identity agent store is read-only; manage the key via the external agent")
+}
+
+// bootstrapConnect connects to bootstrap nodes
+func bootstrapConnect(ctx context.Context, h host.Host, kadDHT *dht.IpfsDHT) {
+	// IPFS bootstrap nodes
+	bootstrapPeers := []string{
+		"/dnsaddr/bootstrap.libp2p.io/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN",
+		"/dnsaddr/bootstrap.libp2p.io/p2p/QmQCU2EcMqAqQPR2i9bChDtGNJchTbq5TbXJJ16u19uLTa",
+		"/dnsaddr/bootstrap.libp2p.io/p2p/QmbLHAnMoJPWSCR5Zhtx6BHJX9KiKNN6tpvbUcqanj75Nb",
+		"/dnsaddr/bootstrap.libp2p.io/p2p/QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt",
+	}
+
+	for _, peerAddr := range bootstrapPeers {
+		ma, err := multiaddr.NewMultiaddr(peerAddr)
+		if err != nil {
+			continue
+		}
+
+		peerInfo, err := peer.AddrInfoFromP2pAddr(ma)
+		if err != nil {
+			continue
+		}
+
+		if err := h.Connect(ctx, *peerInfo); err != nil {
+			log.Printf("⚠ Failed to connect to bootstrap peer %s: %v", peerInfo.ID, err)
+		} else {
+			log.Printf("✓ Connected to bootstrap peer: %s", peerInfo.ID.ShortString())
+		}
+	}
+}
+
+// maintainRelays keeps us holding reservations on desiredRelayCount
+// circuit v2 relays, discovering candidates via the DHT under
+// relayRendezvous and replacing reservations before they expire. A
+// reservation is only dropped once it nears expiry; a relay that drops
+// us earlier is not detected.
+func (h *Helper) maintainRelays(ctx context.Context) {
+	ticker := time.NewTicker(relayRefreshMargin)
+	defer ticker.Stop()
+
+	h.ensureRelays(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.ensureRelays(ctx)
+		}
+	}
+}
+
+// ensureRelays drops reservations that are expiring soon, then tops up
+// to desiredRelayCount by finding and reserving new relay candidates.
+// Any change to the reservation set is reflected in our advertised
+// addresses via updateRelayAddrs.
+func (h *Helper) ensureRelays(ctx context.Context) {
+	h.relaysLock.Lock()
+	dropped := false
+	for relayID, reservation := range h.relays {
+		if time.Until(reservation.Expiration) < relayRefreshMargin {
+			delete(h.relays, relayID)
+			dropped = true
+		}
+	}
+	need := desiredRelayCount - len(h.relays)
+	h.relaysLock.Unlock()
+
+	if dropped {
+		h.updateRelayAddrs()
+	}
+
+	if need <= 0 {
+		return
+	}
+
+	findCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	peerChan, err := h.discovery.FindPeers(findCtx, relayRendezvous)
+	if err != nil {
+		log.Printf("✗ Failed to find relay candidates: %v", err)
+		return
+	}
+
+	changed := false
+	for candidate := range peerChan {
+		if need <= 0 {
+			break
+		}
+		if candidate.ID == h.host.ID() || len(candidate.Addrs) == 0 {
+			continue
+		}
+
+		h.relaysLock.Lock()
+		_, already := h.relays[candidate.ID]
+		h.relaysLock.Unlock()
+		if already {
+			continue
+		}
+
+		if err := h.host.Connect(findCtx, candidate); err != nil {
+			log.Printf("⚠ Failed to connect to relay candidate %s: %v", candidate.ID.ShortString(), err)
+			continue
+		}
+
+		reservation, err := circuitv2client.Reserve(findCtx, h.host, candidate)
+		if err != nil {
+			log.Printf("⚠ Failed to reserve on relay %s: %v", candidate.ID.ShortString(), err)
+			continue
+		}
+
+		h.relaysLock.Lock()
+		h.relays[candidate.ID] = reservation
+		h.relaysLock.Unlock()
+
+		log.Printf("✓ Reserved slot on relay %s (expires %s)", candidate.ID.ShortString(), reservation.Expiration)
+		need--
+		changed = true
+	}
+
+	if changed {
+		h.updateRelayAddrs()
+		h.broadcastRelayStatus()
+	}
+}
+
+// updateRelayAddrs rebuilds the /p2p-circuit addresses we advertise for
+// ourselves from the current reservation set and stores them where
+// addrsFactory (installed on the host in NewHelper) picks them up, so
+// host.Addrs() — and therefore our DHT peer record — includes a
+// dialable relay address for as long as we hold one.
+func (h *Helper) updateRelayAddrs() {
+	h.relaysLock.Lock()
+	addrs := make([]multiaddr.Multiaddr, 0, len(h.relays))
+	for relayID, reservation := range h.relays {
+		addr, err := relayCircuitAddr(relayID, reservation.Addrs, h.host.ID())
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	h.relaysLock.Unlock()
+
+	h.relayAddrs.Store(addrs)
+}
+
+// relayCircuitAddr builds the /p2p-circuit multiaddr used to dial target
+// through relay, preferring one of the relay's own public addresses when
+// we have one and falling back to the relay's bare peer ID otherwise.
+func relayCircuitAddr(relay peer.ID, relayAddrs []multiaddr.Multiaddr, target peer.ID) (multiaddr.Multiaddr, error) {
+	var relayAddr multiaddr.Multiaddr
+	if len(relayAddrs) > 0 {
+		relayWithID, err := multiaddr.NewMultiaddr(fmt.Sprintf("/p2p/%s", relay))
+		if err != nil {
+			return nil, err
+		}
+		relayAddr = relayAddrs[0].Encapsulate(relayWithID)
+	} else {
+		addr, err := multiaddr.NewMultiaddr(fmt.Sprintf("/p2p/%s", relay))
+		if err != nil {
+			return nil, err
+		}
+		relayAddr = addr
+	}
+
+	circuitSuffix, err := multiaddr.NewMultiaddr(fmt.Sprintf("/p2p-circuit/p2p/%s", target))
+	if err != nil {
+		return nil, err
+	}
+
+	return relayAddr.Encapsulate(circuitSuffix), nil
+}
+
+// broadcastRelayStatus sends every connected browser tab a relay-status
+// event describing which relays we currently hold reservations on.
+func (h *Helper) broadcastRelayStatus() {
+	h.relaysLock.Lock()
+	type relayStatus struct {
+		PeerID     string    `json:"peerId"`
+		Expiration time.Time `json:"expiration"`
+	}
+	statuses := make([]relayStatus, 0, len(h.relays))
+	for relayID, reservation := range h.relays {
+		statuses = append(statuses, relayStatus{
+			PeerID:     relayID.String(),
+			Expiration: reservation.Expiration,
+		})
+	}
+	h.relaysLock.Unlock()
+
+	data, err := json.Marshal(statuses)
+	if err != nil {
+		log.Printf("✗ Failed to marshal relay status: %v", err)
+		return
+	}
+
+	h.sessionsLock.RLock()
+	defer h.sessionsLock.RUnlock()
+	for _, sess := range h.sessions {
+		sess.writeJSON(Message{
+			Type: "relay-status",
+			Data: data,
+		})
+	}
+}
+
+// closePeerConn closes the underlying stream and signals the writer
+// goroutine to stop. Safe to call more than once.
+func (pc *peerConn) closePeerConn() {
+	pc.closeOnce.Do(func() {
+		close(pc.done)
+		pc.stream.Close()
+	})
+}
+
+// writeFrame writes a length-prefixed frame: a 4-byte big-endian length
+// followed by payload. Used for SignalingProtocolV2 streams.
+func writeFrame(w io.Writer, payload []byte) error {
+	if len(payload) > maxFrameSize {
+		return fmt.Errorf("message too large: %d bytes exceeds %d byte limit", len(payload), maxFrameSize)
+	}
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(payload)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readFrameHeader reads a frame's 4-byte big-endian length prefix and
+// returns the payload size, rejecting anything over maxFrameSize before
+// the caller allocates a buffer for it so a malicious or buggy peer
+// can't OOM us with an oversized length header.
+func readFrameHeader(r io.Reader) (uint32, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, err
+	}
+
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxFrameSize {
+		return 0, fmt.Errorf("frame size %d exceeds %d byte limit", size, maxFrameSize)
+	}
+	return size, nil
+}
+
+// readFramePayload reads exactly size bytes of frame payload, as
+// previously announced by readFrameHeader.
+func readFramePayload(r io.Reader, size uint32) ([]byte, error) {
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// readFrame reads a single length-prefixed frame in one call, with no
+// notion of deadlines; readPeerMessage calls readFrameHeader and
+// readFramePayload separately instead so it can stagger the read
+// deadline between them.
+func readFrame(r io.Reader) ([]byte, error) {
+	size, err := readFrameHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	return readFramePayload(r, size)
+}
+
+// readPeerMessage reads the next Message off pc, using the framed
+// format for SignalingProtocolV2 streams and falling back to naked JSON
+// decoding for SignalingProtocolV1 peers we haven't upgraded yet. The
+// read deadline starts out generous (peerIdleTimeout), since most of the
+// wait is typically for the *next* message on an idle-but-healthy
+// stream; for V2 it tightens to peerIOTimeout as soon as the length
+// header shows a message is actually in flight, so a peer that starts a
+// frame and then stalls doesn't get to hold the connection open for the
+// full idle window.
+func readPeerMessage(pc *peerConn) (Message, error) {
+	var msg Message
+	if pc.protoVer == protocol.ID(SignalingProtocolV2) {
+		pc.stream.SetReadDeadline(time.Now().Add(peerIdleTimeout))
+		size, err := readFrameHeader(pc.stream)
+		if err != nil {
+			return msg, err
+		}
+
+		pc.stream.SetReadDeadline(time.Now().Add(peerIOTimeout))
+		payload, err := readFramePayload(pc.stream, size)
+		if err != nil {
+			return msg, err
+		}
+
+		err = json.Unmarshal(payload, &msg)
+		return msg, err
+	}
+
+	// SignalingProtocolV1 has no length prefix to tighten the deadline
+	// around, so the whole decode just gets the generous idle bound.
+	pc.stream.SetReadDeadline(time.Now().Add(peerIdleTimeout))
+	err := json.NewDecoder(pc.stream).Decode(&msg)
+	return msg, err
+}
+
+// runPeerWriter drains pc's outbound queue onto its stream until the
+// queue is closed or a write fails, in which case it tears the peer
+// connection down and reports a structured error to the owning session.
+func (h *Helper) runPeerWriter(sess *Session, peerIDStr string, pc *peerConn) {
+	for {
+		select {
+		case <-pc.done:
+			return
+		case msg, ok := <-pc.outbox:
+			if !ok {
+				return
+			}
+
+			pc.stream.SetWriteDeadline(time.Now().Add(peerIOTimeout))
+
+			var err error
+			if pc.protoVer == protocol.ID(SignalingProtocolV2) {
+				var payload []byte
+				payload, err = json.Marshal(msg)
+				if err == nil {
+					err = writeFrame(pc.stream, payload)
+				}
+			} else {
+				err = json.NewEncoder(pc.stream).Encode(msg)
+			}
+
+			if err != nil {
+				log.Printf("✗ Failed to send to peer %s: %v", peerIDStr, err)
+				h.dropPeerConn(sess, peerIDStr, pc, err)
+				return
+			}
+		}
+	}
+}
+
+// dropPeerConn tears down a broken or overflowing peer connection and
+// tells the owning session's browser tab why, instead of silently
+// hanging.
+func (h *Helper) dropPeerConn(sess *Session, peerIDStr string, pc *peerConn, cause error) {
+	sess.peerStreamsLock.Lock()
+	if sess.peerStreams[peerIDStr] == pc {
+		delete(sess.peerStreams, peerIDStr)
+	}
+	sess.peerStreamsLock.Unlock()
+
+	h.peerOwnerLock.Lock()
+	if h.peerOwner[peerIDStr] == sess.id {
+		delete(h.peerOwner, peerIDStr)
+	}
+	h.peerOwnerLock.Unlock()
+
+	pc.closePeerConn()
+
+	sess.writeJSON(Message{
+		Type:   "peer-error",
+		PeerID: peerIDStr,
+		Data:   json.RawMessage(fmt.Sprintf(`{"reason":%q}`, cause.Error())),
+	})
+}
+
+// handleIncomingStream handles incoming signaling streams from remote peers
+func (h *Helper) handleIncomingStream(stream network.Stream) {
+	remotePeer := stream.Conn().RemotePeer()
+	peerIDStr := remotePeer.String()
+	log.Printf("← Incoming signaling stream from %s (protocol %s)", remotePeer.ShortString(), stream.Protocol())
+
+	pc := &peerConn{
+		stream:   stream,
+		protoVer: stream.Protocol(),
+		outbox:   make(chan Message, peerOutboxCapacity),
+		done:     make(chan struct{}),
+	}
+
+	// An unsolicited inbound stream has no owning session yet; claim it
+	// for whichever session already has an outbound connection to this
+	// peer, if any, so replies keep routing to the same browser tab and
+	// can be written back over this same bidirectional stream.
+	sess := h.sessionForPeer(peerIDStr)
+	if sess != nil {
+		sess.peerStreamsLock.Lock()
+		sess.peerStreams[peerIDStr] = pc
+		sess.peerStreamsLock.Unlock()
+		go h.runPeerWriter(sess, peerIDStr, pc)
+	}
+
+	defer func() {
+		pc.closePeerConn()
+		if sess != nil {
+			sess.peerStreamsLock.Lock()
+			if sess.peerStreams[peerIDStr] == pc {
+				delete(sess.peerStreams, peerIDStr)
+			}
+			sess.peerStreamsLock.Unlock()
+		}
+		h.peerOwnerLock.Lock()
+		delete(h.peerOwner, peerIDStr)
+		h.peerOwnerLock.Unlock()
+	}()
+
+	// Read messages from remote peer and forward to the owning browser tab
+	for {
+		msg, err := readPeerMessage(pc)
+		if err != nil {
+			log.Printf("✗ Stream read error from %s: %v", remotePeer.ShortString(), err)
+			return
+		}
+
+		log.Printf("← Received from %s: %s", remotePeer.ShortString(), msg.Type)
+
+		h.deliverToPeerOwner(peerIDStr, msg)
+	}
+}
+
+// sessionForPeer returns the session that owns the signaling stream to
+// peerIDStr, if one has been established.
+func (h *Helper) sessionForPeer(peerIDStr string) *Session {
+	h.peerOwnerLock.RLock()
+	sessionID, ok := h.peerOwner[peerIDStr]
+	h.peerOwnerLock.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	h.sessionsLock.RLock()
+	defer h.sessionsLock.RUnlock()
+	return h.sessions[sessionID]
+}
+
+// deliverToPeerOwner forwards a message arriving from peerIDStr to the
+// session that owns that peer connection. If no session owns the peer
+// yet (a cold inbound connection), the message is broadcast to every
+// connected browser tab so at least one can claim it.
+func (h *Helper) deliverToPeerOwner(peerIDStr string, msg Message) {
+	if sess := h.sessionForPeer(peerIDStr); sess != nil {
+		sess.writeJSON(msg)
+		return
+	}
+
+	h.sessionsLock.RLock()
+	defer h.sessionsLock.RUnlock()
+	for _, sess := range h.sessions {
+		sess.writeJSON(msg)
+	}
+}
+
+// writeJSON writes a message to this session's browser WebSocket, if
+// connected.
+func (s *Session) writeJSON(msg Message) {
+	s.wsLock.Lock()
+	defer s.wsLock.Unlock()
+	if s.wsConn == nil {
+		return
+	}
+	if err := s.wsConn.WriteJSON(msg); err != nil {
+		log.Printf("✗ Failed to forward to browser session %s: %v", s.id, err)
+	}
+}
+
+// generateSessionID returns a random hex session identifier for browser
+// tabs that don't supply their own.
+func generateSessionID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// handleWebSocket handles a WebSocket connection from a browser tab.
+// Each connection is assigned a Session, keyed by a browser-supplied or
+// generated session ID, so multiple tabs can share this libp2p host
+// without clobbering each other's streams or room subscriptions.
+func (h *Helper) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("✗ WebSocket upgrade failed: %v", err)
+		return
+	}
+
+	sessionID := r.URL.Query().Get("sessionId")
+	if sessionID == "" {
+		sessionID, err = generateSessionID()
+		if err != nil {
+			log.Printf("✗ Failed to generate session ID: %v", err)
+			conn.Close()
+			return
+		}
+	}
+
+	sess := &Session{
+		id:                sessionID,
+		wsConn:            conn,
+		peerStreams:       make(map[string]*peerConn),
+		roomSubscriptions: make(map[string]bool),
+	}
+
+	h.sessionsLock.Lock()
+	h.sessions[sessionID] = sess
+	h.sessionsLock.Unlock()
+
+	log.Printf("✓ Browser WebSocket connected (session %s)", sessionID)
+
+	// Send local peer ID and session ID to browser
+	if err := conn.WriteJSON(Message{
+		Type:   "peer-id",
+		PeerID: h.host.ID().String(),
+		Data:   json.RawMessage(fmt.Sprintf(`{"sessionId":%q}`, sessionID)),
+	}); err != nil {
+		log.Printf("✗ Failed to send peer ID: %v", err)
+	} else {
+		log.Printf("→ Sent local PeerID to browser: %s", h.host.ID().ShortString())
+	}
+
+	defer h.closeSession(sess)
+
+	// Read messages from browser
+	for {
+		var msg Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			log.Printf("✗ WebSocket read error (session %s): %v", sessionID, err)
+			return
+		}
+
+		log.Printf("→ Received from browser (session %s): %s", sessionID, msg.Type)
+
+		// Handle message based on type
+		switch msg.Type {
+		case "connect-peer":
+			// Browser wants to connect to a remote peer
+			go h.connectToPeer(sess, msg.To)
+
+		case "offer", "answer", "ice-candidate":
+			// Forward WebRTC signaling to remote peer
+			go h.forwardToPeer(sess, msg)
+
+		case "join-room":
+			// Browser wants to find peers interested in the same room
+			go h.joinRoom(sess, msg.RoomID)
+
+		case "room-broadcast":
+			// Browser wants to publish a payload to everyone in a room
+			go h.publishToRoom(msg.RoomID, msg.Data)
+
+		default:
+			log.Printf("⚠ Unknown message type: %s", msg.Type)
+		}
+	}
+}
+
+// closeSession tears down a disconnected browser tab: its libp2p
+// signaling streams, its room subscriptions, and its entry in the
+// session registry.
+func (h *Helper) closeSession(sess *Session) {
+	sess.wsLock.Lock()
+	sess.wsConn.Close()
+	sess.wsConn = nil
+	sess.wsLock.Unlock()
+
+	sess.peerStreamsLock.Lock()
+	for peerIDStr, pc := range sess.peerStreams {
+		pc.closePeerConn()
+		h.peerOwnerLock.Lock()
+		if h.peerOwner[peerIDStr] == sess.id {
+			delete(h.peerOwner, peerIDStr)
+		}
+		h.peerOwnerLock.Unlock()
+	}
+	sess.peerStreamsLock.Unlock()
+
+	sess.roomSubscriptionsLock.Lock()
+	joinedRooms := make([]string, 0, len(sess.roomSubscriptions))
+	for roomID := range sess.roomSubscriptions {
+		joinedRooms = append(joinedRooms, roomID)
+	}
+	sess.roomSubscriptionsLock.Unlock()
+
+	for _, roomID := range joinedRooms {
+		h.leaveRoom(sess, roomID)
+	}
+
+	h.sessionsLock.Lock()
+	delete(h.sessions, sess.id)
+	h.sessionsLock.Unlock()
+
+	log.Printf("✗ Browser WebSocket disconnected (session %s)", sess.id)
+}
+
+// connectToPeer connects to a remote peer by their PeerID on behalf of
+// the given session, and records that session as the stream's owner so
+// inbound signaling from this peer routes back to the right tab.
+func (h *Helper) connectToPeer(sess *Session, peerIDStr string) {
+	peerID, err := peer.Decode(peerIDStr)
+	if err != nil {
+		log.Printf("✗ Invalid peer ID: %v", err)
+		return
+	}
+
+	log.Printf("→ Connecting to peer %s (session %s)...", peerID.ShortString(), sess.id)
+
+	ctx, cancel := context.WithTimeout(h.ctx, 30*time.Second)
+	defer cancel()
+
+	// Prefer a peer we've already seen on the local network via mDNS so
+	// LAN connections don't need the DHT, or any internet access, at all.
+	h.localPeersLock.RLock()
+	peerInfo, foundLocal := h.localPeers[peerID]
+	h.localPeersLock.RUnlock()
+
+	if foundLocal {
+		log.Printf("→ Using locally-discovered address for peer %s", peerID.ShortString())
+	} else {
+		var err error
+		peerInfo, err = h.dht.FindPeer(ctx, peerID)
+		if err != nil {
+			log.Printf("✗ Failed to find peer in DHT: %v", err)
+			return
+		}
+	}
+
+	// Connect to peer
+	if err := h.host.Connect(ctx, peerInfo); err != nil {
+		log.Printf("✗ Failed to connect to peer: %v", err)
+		return
+	}
+
+	log.Printf("✓ Connected to peer %s", peerID.ShortString())
+
+	// Open signaling stream, preferring the framed V2 protocol; multistream
+	// negotiation falls back to V1 automatically for older peers.
+	stream, err := h.host.NewStream(ctx, peerID, protocol.ID(SignalingProtocolV2), protocol.ID(SignalingProtocolV1))
+	if err != nil {
+		log.Printf("✗ Failed to open stream: %v", err)
+		return
+	}
+
+	pc := &peerConn{
+		stream:   stream,
+		protoVer: stream.Protocol(),
+		outbox:   make(chan Message, peerOutboxCapacity),
+		done:     make(chan struct{}),
+	}
+
+	// Store the connection against this session, and record session
+	// ownership of the peer so inbound stream reads are routed back here.
+	sess.peerStreamsLock.Lock()
+	sess.peerStreams[peerIDStr] = pc
+	sess.peerStreamsLock.Unlock()
+
+	h.peerOwnerLock.Lock()
+	h.peerOwner[peerIDStr] = sess.id
+	h.peerOwnerLock.Unlock()
+
+	go h.runPeerWriter(sess, peerIDStr, pc)
+
+	log.Printf("✓ Signaling stream established with %s (protocol %s)", peerID.ShortString(), pc.protoVer)
+
+	sess.writeJSON(Message{
+		Type:   "peer-connected",
+		PeerID: peerIDStr,
+	})
+}
+
+// forwardToPeer forwards WebRTC signaling from a session to a remote
+// peer via libp2p, opening a stream first if this session doesn't have
+// one yet. The message is handed to the peer's outbound queue rather
+// than written directly, so a slow peer applies backpressure to its own
+// queue instead of blocking this goroutine.
+func (h *Helper) forwardToPeer(sess *Session, msg Message) {
+	if msg.To == "" {
+		log.Printf("✗ No target peer specified")
+		return
+	}
+
+	sess.peerStreamsLock.RLock()
+	pc, ok := sess.peerStreams[msg.To]
+	sess.peerStreamsLock.RUnlock()
+
+	if !ok {
+		log.Printf("✗ No stream to peer %s, connecting first...", msg.To)
+		h.connectToPeer(sess, msg.To)
+
+		// Wait a bit and retry
+		time.Sleep(2 * time.Second)
+		sess.peerStreamsLock.RLock()
+		pc, ok = sess.peerStreams[msg.To]
+		sess.peerStreamsLock.RUnlock()
+
+		if !ok {
+			log.Printf("✗ Still no stream to peer %s", msg.To)
+			return
+		}
+	}
+
+	select {
+	case pc.outbox <- msg:
+		log.Printf("→ Queued %s for peer", msg.Type)
+	default:
+		log.Printf("✗ Outbox full for peer %s, dropping connection", msg.To)
+		h.dropPeerConn(sess, msg.To, pc, fmt.Errorf("outbound queue full (%d messages)", peerOutboxCapacity))
+	}
+}
+
+// roomRendezvous derives the DHT rendezvous string and pubsub topic name
+// for a browser-supplied room ID. Hashing keeps arbitrary user-chosen
+// room names from leaking structure into the DHT key.
+func roomRendezvous(roomID string) string {
+	sum := sha256.Sum256([]byte(roomID))
+	return rendezvousPrefix + hex.EncodeToString(sum[:])
+}
+
+// joinRoom subscribes sess to roomID, advertising our presence under
+// the room's rendezvous string and creating the underlying pubsub topic
+// on first join. Later sessions joining an already-active room just
+// register as additional subscribers.
+func (h *Helper) joinRoom(sess *Session, roomID string) {
+	if roomID == "" {
+		log.Println("✗ join-room requires a roomId")
+		return
+	}
+
+	h.roomsLock.Lock()
+	room, exists := h.rooms[roomID]
+	if !exists {
+		rendezvous := roomRendezvous(roomID)
+
+		topic, err := h.pubsub.Join(rendezvous)
+		if err != nil {
+			h.roomsLock.Unlock()
+			log.Printf("✗ Failed to join pubsub topic for room %s: %v", roomID, err)
+			return
+		}
+
+		sub, err := topic.Subscribe()
+		if err != nil {
+			topic.Close()
+			h.roomsLock.Unlock()
+			log.Printf("✗ Failed to subscribe to room %s: %v", roomID, err)
+			return
+		}
+
+		roomCtx, cancel := context.WithCancel(h.ctx)
+		room = &Room{
+			id:          roomID,
+			rendezvous:  rendezvous,
+			topic:       topic,
+			sub:         sub,
+			cancel:      cancel,
+			seenPeers:   make(map[peer.ID]bool),
+			subscribers: make(map[string]bool),
+		}
+		h.rooms[roomID] = room
+
+		dutil.Advertise(roomCtx, h.discovery, rendezvous)
+		log.Printf("✓ Advertising in room %s (rendezvous %s)", roomID, rendezvous)
+
+		go h.discoverRoomPeers(roomCtx, room)
+		go h.readRoomMessages(roomCtx, room)
+	}
+	h.roomsLock.Unlock()
+
+	room.subscribersLock.Lock()
+	room.subscribers[sess.id] = true
+	room.subscribersLock.Unlock()
+
+	sess.roomSubscriptionsLock.Lock()
+	sess.roomSubscriptions[roomID] = true
+	sess.roomSubscriptionsLock.Unlock()
+
+	log.Printf("✓ Session %s joined room %s", sess.id, roomID)
+}
+
+// leaveRoom removes sess from roomID's subscriber set. Once a room has
+// no subscribers left, its pubsub topic and discovery loops are torn
+// down entirely.
+func (h *Helper) leaveRoom(sess *Session, roomID string) {
+	sess.roomSubscriptionsLock.Lock()
+	delete(sess.roomSubscriptions, roomID)
+	sess.roomSubscriptionsLock.Unlock()
+
+	h.roomsLock.Lock()
+	room, ok := h.rooms[roomID]
+	if !ok {
+		h.roomsLock.Unlock()
+		return
+	}
+
+	room.subscribersLock.Lock()
+	delete(room.subscribers, sess.id)
+	empty := len(room.subscribers) == 0
+	room.subscribersLock.Unlock()
+
+	if empty {
+		delete(h.rooms, roomID)
+	}
+	h.roomsLock.Unlock()
+
+	if empty {
+		room.cancel()
+		room.sub.Cancel()
+		room.topic.Close()
+		log.Printf("✓ Room %s has no subscribers left, tearing down", roomID)
+	}
+}
+
+// discoverRoomPeers periodically queries the DHT for peers advertising
+// the room and forwards newly-seen ones to the browser as a
+// peer-discovered event.
+func (h *Helper) discoverRoomPeers(ctx context.Context, room *Room) {
+	ticker := time.NewTicker(roomDiscoveryInterval)
+	defer ticker.Stop()
+
+	findOnce := func() {
+		peerChan, err := h.discovery.FindPeers(ctx, room.rendezvous)
+		if err != nil {
+			log.Printf("✗ FindPeers failed for room %s: %v", room.id, err)
+			return
+		}
+
+		for p := range peerChan {
+			if p.ID == h.host.ID() || len(p.Addrs) == 0 {
+				continue
+			}
+
+			room.seenLock.Lock()
+			alreadySeen := room.seenPeers[p.ID]
+			room.seenPeers[p.ID] = true
+			room.seenLock.Unlock()
+
+			if alreadySeen {
+				continue
+			}
+
+			log.Printf("✓ Discovered peer %s in room %s", p.ID.ShortString(), room.id)
+
+			h.broadcastToRoom(room, Message{
+				Type:   "peer-discovered",
+				PeerID: p.ID.String(),
+				RoomID: room.id,
+			})
+		}
+	}
+
+	findOnce()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			findOnce()
+		}
+	}
+}
+
+// readRoomMessages relays pubsub messages published by other room
+// members to the browser as room-message events, letting browsers
+// exchange chat/presence/state payloads without a data channel.
+func (h *Helper) readRoomMessages(ctx context.Context, room *Room) {
+	for {
+		psMsg, err := room.sub.Next(ctx)
+		if err != nil {
+			return
+		}
+
+		if psMsg.ReceivedFrom == h.host.ID() {
+			continue
+		}
+
+		h.broadcastToRoom(room, Message{
+			Type:   "room-message",
+			RoomID: room.id,
+			PeerID: psMsg.ReceivedFrom.String(),
+			Data:   json.RawMessage(psMsg.Data),
+		})
+	}
+}
+
+// broadcastToRoom delivers msg to every session currently subscribed to
+// room, rather than every connected browser tab.
+func (h *Helper) broadcastToRoom(room *Room, msg Message) {
+	room.subscribersLock.Lock()
+	sessionIDs := make([]string, 0, len(room.subscribers))
+	for sessionID := range room.subscribers {
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	room.subscribersLock.Unlock()
+
+	h.sessionsLock.RLock()
+	defer h.sessionsLock.RUnlock()
+	for _, sessionID := range sessionIDs {
+		if sess, ok := h.sessions[sessionID]; ok {
+			sess.writeJSON(msg)
+		}
+	}
+}
+
+// publishToRoom broadcasts a browser-supplied payload to every peer
+// subscribed to the room's pubsub topic.
+func (h *Helper) publishToRoom(roomID string, data json.RawMessage) {
+	h.roomsLock.Lock()
+	room, ok := h.rooms[roomID]
+	h.roomsLock.Unlock()
+
+	if !ok {
+		log.Printf("✗ Not in room %s, cannot broadcast", roomID)
+		return
+	}
+
+	if err := room.topic.Publish(h.ctx, data); err != nil {
+		log.Printf("✗ Failed to publish to room %s: %v", roomID, err)
+	}
+}
+
+// Start starts the helper application
+func (h *Helper) Start() error {
+	// Print local peer info
+	fmt.Println("\n╔══════════════════════════════════════════════════════════════╗")
+	fmt.Println("║          SECURE.LINK P2P Helper Running                     ║")
+	fmt.Println("╚══════════════════════════════════════════════════════════════╝")
+	fmt.Printf("\n🆔 Your Peer ID: %s\n", h.host.ID().String())
+	fmt.Printf("🌐 WebSocket Server: ws://127.0.0.1:%d\n", WSPort)
+	fmt.Println("\n📡 Listening addresses:")
+	for _, addr := range h.host.Addrs() {
+		fmt.Printf("   - %s/p2p/%s\n", addr, h.host.ID().ShortString())
+	}
+	fmt.Println("\n✓ Ready! Open your browser and connect to the app.")
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━\n")
+
+	// Start WebSocket server
+	http.HandleFunc("/", h.handleWebSocket)
+	server := &http.Server{
+		Addr:    fmt.Sprintf("127.0.0.1:%d", WSPort),
+		Handler: http.DefaultServeMux,
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("✗ WebSocket server error: %v", err)
+		}
+	}()
+
+	// Wait for shutdown signal
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+
+	fmt.Println("\n⏳ Shutting down...")
+
+	// Cleanup
+	server.Shutdown(context.Background())
+	h.cancel()
+	h.host.Close()
+
+	fmt.Println("✓ Goodbye!")
+	return nil
+}
+
+func main() {
+	relayServer := flag.Bool("relay-server", false, "also run as a circuit v2 relay server for other helpers")
+	identitySpec := flag.String("identity", "default", `identity storage to use: "default" (OS config dir), "encrypted" (passphrase-protected), or "agent:<unix socket path>"`)
+	flag.Parse()
+
+	identityStore, err := newIdentityStore(*identitySpec)
+	if err != nil {
+		log.Fatalf("✗ Invalid --identity: %v", err)
+	}
+
+	ctx := context.Background()
+	helper, err := NewHelper(ctx, *relayServer, identityStore)
+	if err != nil {
+		log.Fatalf("✗ Failed to create helper: %v", err)
+	}
+
+	if err := helper.Start(); err != nil {
+		log.Fatalf("✗ Helper error: %v", err)
+	}
+}